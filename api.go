@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const githubDefaultApiUrl = "https://api.github.com"
+const gitlabDefaultApiUrl = "https://gitlab.com"
+
+// upper bound for following pagination cursors, guards against endless loops on misbehaving servers
+const maxPages = 100
+
+var client *http.Client
+
+type PageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+/*
+returns the graphql endpoint for the configured provider, following the path conventions of the products:
+
+github.com:         https://api.github.com/graphql
+GitHub Enterprise:  https://{host}/api/graphql (REST API at /api/v3)
+gitlab.com / self-hosted GitLab: https://{host}/api/graphql (REST API at /api/v4)
+
+api_url may be given as the host, the REST API URL or the graphql endpoint itself.
+*/
+func graphQLUrl(conf Conf) string {
+	if conf.Provider == "github" {
+		apiUrl := apiBaseUrl(conf, githubDefaultApiUrl)
+		if strings.HasSuffix(apiUrl, "/graphql") {
+			return apiUrl
+		}
+		if strings.HasSuffix(apiUrl, "/api/v3") {
+			return strings.TrimSuffix(apiUrl, "/v3") + "/graphql"
+		}
+		if strings.HasSuffix(apiUrl, "/api") {
+			return apiUrl + "/graphql"
+		}
+		parsed, err := url.Parse(apiUrl)
+		if err != nil {
+			fail("Invalid api url %v: %v", conf.ApiUrl, err)
+		}
+		if parsed.Host == "github.com" || parsed.Host == "api.github.com" {
+			return "https://api.github.com/graphql"
+		}
+		return apiUrl + "/api/graphql"
+	} else if conf.Provider == "gitlab" {
+		return gitlabBaseUrl(conf) + "/api/graphql"
+	}
+	fail("Invalid provider %v", conf.Provider)
+	return ""
+}
+
+// root URL of the GitLab instance, i.e. without the /api/... part
+func gitlabBaseUrl(conf Conf) string {
+	apiUrl := apiBaseUrl(conf, gitlabDefaultApiUrl)
+	for _, suffix := range []string{"/api/graphql", "/api/v4", "/api"} {
+		if strings.HasSuffix(apiUrl, suffix) {
+			return strings.TrimSuffix(apiUrl, suffix)
+		}
+	}
+	return apiUrl
+}
+
+func bitbucketApiUrl(conf Conf) string {
+	apiUrl := apiBaseUrl(conf, bitbucketDefaultApiUrl)
+	if !strings.HasSuffix(apiUrl, "/2.0") {
+		apiUrl += "/2.0"
+	}
+	return apiUrl
+}
+
+func apiBaseUrl(conf Conf, defaultUrl string) string {
+	apiUrl := strings.TrimSuffix(strings.TrimSpace(conf.ApiUrl), "/")
+	if len(apiUrl) == 0 {
+		return defaultUrl
+	}
+	if !strings.Contains(apiUrl, "://") {
+		apiUrl = "https://" + apiUrl
+	}
+	return apiUrl
+}
+
+// the http client for all provider requests, trusting the certificates of ca_bundle in addition to the system ones
+func httpClient(conf Conf) *http.Client {
+	if client != nil {
+		return client
+	}
+	client = &http.Client{Timeout: apiTimeout(conf)}
+	if len(conf.CaBundle) == 0 {
+		return client
+	}
+	pem, err := ioutil.ReadFile(conf.CaBundle)
+	if err != nil {
+		fail("failed to read CA bundle %v: %v", conf.CaBundle, err)
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil || rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(pem) {
+		fail("CA bundle %v does not contain any PEM encoded certificates", conf.CaBundle)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	client.Transport = transport
+	return client
+}
+
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Type    string        `json:"type"`
+	Path    []interface{} `json:"path"`
+}
+
+/*
+sends the query with its variables and decodes the response into the given struct.
+
+Errors reported in the response fail the step, except for objects nested in the queried repository / project that
+do not exist (e.g. an unknown pull request number). Those are logged and leave the respective data empty.
+*/
+func graphQLRequest(query string, variables map[string]interface{}, conf Conf, graphQLResponse interface{}) {
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		fail("failed to encode graphql request: %v\n", err)
+	}
+	response, body := sendRequest(conf, "graphql request", func() (*http.Request, error) {
+		request, err := http.NewRequest("POST", graphQLUrl(conf), bytes.NewReader(requestBody))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Add("Content-Type", "application/json")
+		request.Header.Add("Authorization", strings.Replace("Bearer $AuthToken", "$AuthToken", conf.AuthToken, 1))
+		request.Header.Add("User-Agent", "tvietinghoff/bitrise-step-variant-labels")
+		return request, nil
+	})
+	if response.StatusCode != 200 {
+		fail("graphql request returned %v\n%v\n", response.Status, string(body))
+	}
+
+	var errorResponse struct {
+		Errors []GraphQLError `json:"errors"`
+	}
+	err = json.Unmarshal(body, &errorResponse)
+	if err != nil {
+		fail("failed to decode graphql response: %v\n", err)
+	}
+	var messages []string
+	for _, graphQLError := range errorResponse.Errors {
+		if graphQLError.Type == "NOT_FOUND" && len(graphQLError.Path) > 1 {
+			log.Warnf("%v", graphQLError.Message)
+			continue
+		}
+		message := graphQLError.Message
+		if len(graphQLError.Path) > 0 {
+			message = fmt.Sprintf("%v (at %v)", message, graphQLError.path())
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) > 0 {
+		fail("graphql request failed:\n%v", strings.Join(messages, "\n"))
+	}
+
+	err = json.Unmarshal(body, graphQLResponse)
+	if err != nil {
+		fail("failed to decode graphql response: %v\n", err)
+	}
+}
+
+func (graphQLError GraphQLError) path() string {
+	var segments []string
+	for _, segment := range graphQLError.Path {
+		segments = append(segments, fmt.Sprintf("%v", segment))
+	}
+	return strings.Join(segments, ".")
+}
+
+func checkNextPage(pageInfo PageInfo, page int, what string, number int) {
+	if len(pageInfo.EndCursor) == 0 {
+		fail("Failed to fetch all %s #%d: more pages announced, but no cursor returned", what, number)
+	}
+	if page >= maxPages {
+		fail("Failed to fetch all %s #%d: giving up after %d pages", what, number, maxPages)
+	}
+}
+
+func checkLabelsComplete(number int, fetched int, total int) {
+	if fetched < total {
+		fail("Label set of pull request #%d is incomplete: fetched %d of %d labels. Variants cannot be determined reliably.", number, fetched, total)
+	}
+}
+
+// GET request to a REST API. Returns status, headers and body of successful or not found responses.
+func restRequest(conf Conf, requestUrl string) (int, http.Header, string) {
+	response, body := sendRequest(conf, conf.Provider+" request", func() (*http.Request, error) {
+		request, err := http.NewRequest("GET", requestUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Add("Accept", "application/json")
+		if strings.Contains(conf.AuthToken, ":") {
+			// username:app_password
+			parts := strings.SplitN(conf.AuthToken, ":", 2)
+			request.SetBasicAuth(parts[0], parts[1])
+		} else {
+			request.Header.Add("Authorization", "Bearer "+conf.AuthToken)
+		}
+		request.Header.Add("User-Agent", "tvietinghoff/bitrise-step-variant-labels")
+		return request, nil
+	})
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+		fail("%v request returned %v\n%v\n", conf.Provider, response.Status, string(body))
+	}
+	return response.StatusCode, response.Header, string(body)
+}
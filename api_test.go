@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestGraphQLUrl(t *testing.T) {
+	tests := []struct {
+		provider string
+		apiUrl   string
+		want     string
+	}{
+		{"github", "", "https://api.github.com/graphql"},
+		{"github", "https://api.github.com", "https://api.github.com/graphql"},
+		{"github", "https://github.com/", "https://api.github.com/graphql"},
+		{"github", "https://ghe.example.com/api/v3", "https://ghe.example.com/api/graphql"},
+		{"github", "https://ghe.example.com/api/v3/", "https://ghe.example.com/api/graphql"},
+		{"github", "https://ghe.example.com/api", "https://ghe.example.com/api/graphql"},
+		{"github", "https://ghe.example.com/api/graphql", "https://ghe.example.com/api/graphql"},
+		{"github", "ghe.example.com", "https://ghe.example.com/api/graphql"},
+		{"github", "http://localhost:8080", "http://localhost:8080/api/graphql"},
+		{"gitlab", "", "https://gitlab.com/api/graphql"},
+		{"gitlab", "https://gitlab.com", "https://gitlab.com/api/graphql"},
+		{"gitlab", "https://gitlab.example.com/api/v4", "https://gitlab.example.com/api/graphql"},
+		{"gitlab", "https://gitlab.example.com/api/graphql", "https://gitlab.example.com/api/graphql"},
+		{"gitlab", "https://gitlab.example.com/api", "https://gitlab.example.com/api/graphql"},
+		{"gitlab", "gitlab.example.com/", "https://gitlab.example.com/api/graphql"},
+	}
+	for _, test := range tests {
+		if got := graphQLUrl(Conf{Provider: test.provider, ApiUrl: test.apiUrl}); got != test.want {
+			t.Errorf("graphQLUrl(%v, %q) = %v, want %v", test.provider, test.apiUrl, got, test.want)
+		}
+	}
+}
+
+func TestGitlabBaseUrl(t *testing.T) {
+	tests := map[string]string{
+		"":                                   "https://gitlab.com",
+		"https://gitlab.example.com/api/v4":  "https://gitlab.example.com",
+		"https://gitlab.example.com/api/v4/": "https://gitlab.example.com",
+		"gitlab.example.com":                 "https://gitlab.example.com",
+		"http://localhost:8080/api":          "http://localhost:8080",
+	}
+	for apiUrl, want := range tests {
+		if got := gitlabBaseUrl(Conf{Provider: "gitlab", ApiUrl: apiUrl}); got != want {
+			t.Errorf("gitlabBaseUrl(%q) = %v, want %v", apiUrl, got, want)
+		}
+	}
+}
+
+func TestBitbucketApiUrl(t *testing.T) {
+	tests := map[string]string{
+		"":                                   "https://api.bitbucket.org/2.0",
+		"https://api.bitbucket.org/2.0/":     "https://api.bitbucket.org/2.0",
+		"bitbucket.example.com":              "https://bitbucket.example.com/2.0",
+		"http://localhost:8080/mock/2.0":     "http://localhost:8080/mock/2.0",
+		"http://localhost:8080/mock/2.0/ ":   "http://localhost:8080/mock/2.0",
+		"https://bitbucket.example.com/rest": "https://bitbucket.example.com/rest/2.0",
+	}
+	for apiUrl, want := range tests {
+		if got := bitbucketApiUrl(Conf{Provider: "bitbucket", ApiUrl: apiUrl}); got != want {
+			t.Errorf("bitbucketApiUrl(%q) = %v, want %v", apiUrl, got, want)
+		}
+	}
+}
+
+func TestHttpClientTrustsCaBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caBundle, certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { client = nil }()
+
+	client = nil
+	if _, _, err := retryRequest(httpClient(Conf{}), Conf{}, "test request", get(server.URL)); err == nil {
+		t.Errorf("got no error without ca_bundle, want the self-signed certificate to be rejected")
+	}
+
+	client = nil
+	conf := Conf{CaBundle: caBundle}
+	response, _, err := retryRequest(httpClient(conf), conf, "test request", get(server.URL))
+	if err != nil {
+		t.Fatalf("got %v with ca_bundle, want the certificate to be trusted", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("got %v, want 200 OK", response.Status)
+	}
+}
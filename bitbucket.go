@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const bitbucketDefaultApiUrl = "https://api.bitbucket.org/2.0"
+
+type PullRequestBitbucket struct {
+	Id          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+	Summary     struct {
+		Raw  string `json:"raw"`
+		Html string `json:"html"`
+	} `json:"summary"`
+	Rendered struct {
+		Title struct {
+			Html string `json:"html"`
+		} `json:"title"`
+		Description struct {
+			Html string `json:"html"`
+		} `json:"description"`
+	} `json:"rendered"`
+	MergeCommit struct {
+		Hash string `json:"hash"`
+	} `json:"merge_commit"`
+	Links struct {
+		Html struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+	Author struct {
+		DisplayName string `json:"display_name"`
+		Nickname    string `json:"nickname"`
+	} `json:"author"`
+	Source      BranchRefBitbucket `json:"source"`
+	Destination BranchRefBitbucket `json:"destination"`
+}
+
+type BranchRefBitbucket struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+}
+
+type PullRequestsResponseBitbucket struct {
+	Values []PullRequestBitbucket `json:"values"`
+	Next   string                 `json:"next"`
+}
+
+var bitbucketTitleTagRegex = regexp.MustCompile(`\[([^\[\]]+)\]`)
+var bitbucketLabelsHeadingRegex = regexp.MustCompile(`(?i)^\s*(?:#+\s*labels\s*:?|\**labels\s*:\**)\s*(.*)$`)
+var bitbucketListItemRegex = regexp.MustCompile(`^\s*[-*+]\s+(.+)$`)
+
+type bitbucketLabelSource struct{}
+
+func init() {
+	registerLabelSource("bitbucket", bitbucketLabelSource{})
+}
+
+func (bitbucketLabelSource) Validate(conf Conf) error {
+	if len(conf.RepoName) == 0 {
+		return errors.New("Missing repo name argument")
+	}
+	if len(conf.RepoOwner) == 0 {
+		return errors.New("Missing repo owner argument")
+	}
+	for _, source := range bitbucketLabelSources(conf) {
+		if source != "title" && source != "description" {
+			return fmt.Errorf("Invalid bitbucket label source: %v. Allowed are: title, description", source)
+		}
+	}
+	return nil
+}
+
+func (bitbucketLabelSource) FetchPullRequest(conf Conf, number int) *PullRequest {
+	pullRequest := fetchPullRequestBitbucket(conf, number)
+	if pullRequest == nil {
+		return nil
+	}
+	return pullRequest.toPullRequest(conf)
+}
+
+func (bitbucketLabelSource) FetchPullRequestForCommit(conf Conf, commitHash string) *PullRequest {
+	pullRequest := fetchPullRequestForCommitBitbucket(conf, commitHash)
+	if pullRequest == nil {
+		return nil
+	}
+	return pullRequest.toPullRequest(conf)
+}
+
+func (pullRequest PullRequestBitbucket) toPullRequest(conf Conf) *PullRequest {
+	titleHtml := pullRequest.Rendered.Title.Html
+	if len(titleHtml) == 0 {
+		titleHtml = html.EscapeString(pullRequest.Title)
+	}
+	descriptionHtml := pullRequest.Rendered.Description.Html
+	if len(descriptionHtml) == 0 {
+		descriptionHtml = pullRequest.Summary.Html
+	}
+	author := pullRequest.Author.Nickname
+	if len(author) == 0 {
+		author = pullRequest.Author.DisplayName
+	}
+	return &PullRequest{
+		Number:          pullRequest.Id,
+		Url:             pullRequest.Links.Html.Href,
+		Title:           pullRequest.Title,
+		TitleHtml:       titleHtml,
+		Description:     pullRequest.Description,
+		DescriptionHtml: descriptionHtml,
+		MergeCommitSha:  pullRequest.MergeCommit.Hash,
+		Author:          author,
+		SourceBranch:    pullRequest.Source.Branch.Name,
+		TargetBranch:    pullRequest.Destination.Branch.Name,
+		Labels:          labelsFromPullRequestBitbucket(conf, pullRequest),
+	}
+}
+
+/*
+Bitbucket has no pull request labels, so they are taken from the sources configured in bitbucket_labels:
+
+"title": every "[label]" tag in the pull request title, e.g. "[full][blue] Fix login" yields full and blue.
+
+"description": a "Labels:" line in the description, followed by a comma-separated list and / or a list of
+"- label" items on the subsequent lines.
+*/
+func labelsFromPullRequestBitbucket(conf Conf, pullRequest PullRequestBitbucket) []string {
+	var labels []string
+	for _, source := range bitbucketLabelSources(conf) {
+		switch source {
+		case "title":
+			for _, label := range labelsFromTitleTags(pullRequest.Title) {
+				labels = appendLabel(labels, label)
+			}
+		case "description":
+			description := pullRequest.Description
+			if len(description) == 0 {
+				description = pullRequest.Summary.Raw
+			}
+			for _, label := range labelsFromDescriptionSection(description) {
+				labels = appendLabel(labels, label)
+			}
+		}
+	}
+	return labels
+}
+
+// the sources of bitbucket_labels, validated by Validate
+func bitbucketLabelSources(conf Conf) []string {
+	sources := conf.BitbucketLabels
+	if len(strings.TrimSpace(sources)) == 0 {
+		sources = "title,description"
+	}
+	var result []string
+	for _, source := range strings.Split(sources, ",") {
+		result = append(result, strings.TrimSpace(source))
+	}
+	return result
+}
+
+func labelsFromTitleTags(title string) []string {
+	var labels []string
+	for _, match := range bitbucketTitleTagRegex.FindAllStringSubmatch(title, -1) {
+		labels = appendLabel(labels, match[1])
+	}
+	return labels
+}
+
+func labelsFromDescriptionSection(description string) []string {
+	var labels []string
+	inSection := false
+	for _, line := range strings.Split(description, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !inSection {
+			matches := bitbucketLabelsHeadingRegex.FindStringSubmatch(line)
+			if matches == nil {
+				continue
+			}
+			inSection = true
+			for _, label := range strings.Split(matches[1], ",") {
+				labels = appendLabel(labels, label)
+			}
+			continue
+		}
+		if matches := bitbucketListItemRegex.FindStringSubmatch(line); matches != nil {
+			labels = appendLabel(labels, matches[1])
+			continue
+		}
+		if len(strings.TrimSpace(line)) == 0 && len(labels) == 0 {
+			continue
+		}
+		break
+	}
+	return labels
+}
+
+func appendLabel(labels []string, label string) []string {
+	label = strings.Trim(strings.TrimSpace(label), "`")
+	if len(label) == 0 {
+		return labels
+	}
+	for _, existing := range labels {
+		if existing == label {
+			return labels
+		}
+	}
+	return append(labels, label)
+}
+
+func fetchPullRequestBitbucket(conf Conf, id int) *PullRequestBitbucket {
+	status, _, jsonResponse := restRequest(conf, bitbucketRepositoryUrl(conf)+fmt.Sprintf("/pullrequests/%d", id))
+	if status == http.StatusNotFound {
+		return nil
+	}
+	var pullRequest PullRequestBitbucket
+	err := json.NewDecoder(strings.NewReader(jsonResponse)).Decode(&pullRequest)
+	if err != nil {
+		fail("failed to decode bitbucket response: %v\n", err)
+	}
+	return &pullRequest
+}
+
+func fetchPullRequestForCommitBitbucket(conf Conf, commitHash string) *PullRequestBitbucket {
+	nextUrl := bitbucketRepositoryUrl(conf) + "/commit/" + url.PathEscape(commitHash) + "/pullrequests"
+	var candidate *PullRequestBitbucket
+	for len(nextUrl) > 0 {
+		status, _, jsonResponse := restRequest(conf, nextUrl)
+		if status == http.StatusNotFound {
+			log.Warnf("Commit %s not found or pull request links not indexed yet for %s/%s", commitHash, conf.RepoOwner, conf.RepoName)
+			return nil
+		}
+		var response PullRequestsResponseBitbucket
+		err := json.NewDecoder(strings.NewReader(jsonResponse)).Decode(&response)
+		if err != nil {
+			fail("failed to decode bitbucket response: %v\n", err)
+		}
+		for i, pullRequest := range response.Values {
+			if sameCommit(pullRequest.MergeCommit.Hash, commitHash) {
+				// bitbucket reports abbreviated merge commit hashes
+				return fetchPullRequestBitbucket(conf, pullRequest.Id)
+			}
+			if candidate == nil {
+				candidate = &response.Values[i]
+			}
+		}
+		nextUrl = response.Next
+	}
+	if candidate == nil {
+		return nil
+	}
+	return fetchPullRequestBitbucket(conf, candidate.Id)
+}
+
+func bitbucketRepositoryUrl(conf Conf) string {
+	return bitbucketApiUrl(conf) + "/repositories/" + url.PathEscape(conf.RepoOwner) + "/" + url.PathEscape(conf.RepoName)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLabelsFromTitleTags(t *testing.T) {
+	tests := map[string][]string{
+		"[full][blue] Fix login":       {"full", "blue"},
+		"Fix login [ full ] [full]":    {"full"},
+		"Fix [ios] and [`android`]":    {"ios", "android"},
+		"Fix login":                    nil,
+		"Nested [[full]] and [] empty": {"full"},
+	}
+	for title, want := range tests {
+		if got := labelsFromTitleTags(title); !reflect.DeepEqual(got, want) {
+			t.Errorf("labelsFromTitleTags(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
+
+func TestLabelsFromDescriptionSection(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        []string
+	}{
+		{"inline list", "Fixes the login.\n\nLabels: full, blue\n\nMore text", []string{"full", "blue"}},
+		{"markdown heading with items", "## Labels\n\n- full\n* blue\n+ `teal`\n\nOther text\n- not a label", []string{"full", "blue", "teal"}},
+		{"bold heading", "**Labels:** demo\r\n- orange\r\n", []string{"demo", "orange"}},
+		{"case insensitive", "labels: Full", []string{"Full"}},
+		{"inline list and items", "Labels: full\n- blue\n- full", []string{"full", "blue"}},
+		{"section ends at text", "Labels:\n- full\nsome text\n- blue", []string{"full"}},
+		{"no section", "Fixes the login.\n- full", nil},
+		{"empty", "", nil},
+	}
+	for _, test := range tests {
+		if got := labelsFromDescriptionSection(test.description); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLabelsFromPullRequestBitbucket(t *testing.T) {
+	pullRequest := PullRequestBitbucket{Title: "[full] Fix login", Description: "Labels: blue, full"}
+	tests := map[string][]string{
+		"":            {"full", "blue"},
+		"title":       {"full"},
+		"description": {"blue", "full"},
+	}
+	for sources, want := range tests {
+		conf := Conf{BitbucketLabels: sources}
+		if got := labelsFromPullRequestBitbucket(conf, pullRequest); !reflect.DeepEqual(got, want) {
+			t.Errorf("sources %q: got %q, want %q", sources, got, want)
+		}
+	}
+
+	pullRequest.Description = ""
+	pullRequest.Summary.Raw = "Labels: demo"
+	if got := labelsFromPullRequestBitbucket(Conf{BitbucketLabels: "description"}, pullRequest); !reflect.DeepEqual(got, []string{"demo"}) {
+		t.Errorf("got %q, want the labels of the summary if the description is empty", got)
+	}
+}
+
+func TestBitbucketValidateLabelSources(t *testing.T) {
+	conf := Conf{RepoOwner: "owner", RepoName: "repo", BitbucketLabels: "title, description"}
+	if err := (bitbucketLabelSource{}).Validate(conf); err != nil {
+		t.Errorf("got %v for valid sources", err)
+	}
+	conf.BitbucketLabels = "title,comments"
+	if err := (bitbucketLabelSource{}).Validate(conf); err == nil {
+		t.Errorf("got no error for the invalid source comments")
+	}
+}
+
+/*
+a stand-in for the Bitbucket API answering the request URIs below /2.0/repositories/owner/repo with the given JSON, in
+which {server} is replaced by the server URL. Other requests get 404. Records the requested URIs and the Authorization
+headers.
+*/
+func bitbucketServer(t *testing.T, responses map[string]string) (Conf, *[]string, *[]string) {
+	var requests, authorizations []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		uri := strings.TrimPrefix(request.URL.RequestURI(), "/2.0/repositories/owner/repo")
+		requests = append(requests, uri)
+		authorizations = append(authorizations, request.Header.Get("Authorization"))
+		response, ok := responses[uri]
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(strings.Replace(response, "{server}", server.URL, -1)))
+	}))
+	t.Cleanup(server.Close)
+	client = nil
+	t.Cleanup(func() { client = nil })
+	conf := Conf{Provider: "bitbucket", ApiUrl: server.URL, RepoOwner: "owner", RepoName: "repo", AuthToken: "token"}
+	return conf, &requests, &authorizations
+}
+
+func TestFetchPullRequestBitbucket(t *testing.T) {
+	conf, requests, _ := bitbucketServer(t, map[string]string{
+		"/pullrequests/3": `{"id": 3, "title": "[full] Fix login", "description": "Labels: blue",
+			"merge_commit": {"hash": "0123456789ab"}, "links": {"html": {"href": "https://bitbucket.org/owner/repo/pull-requests/3"}}}`,
+	})
+
+	pullRequest := (bitbucketLabelSource{}).FetchPullRequest(conf, 3)
+	if pullRequest == nil {
+		t.Fatalf("got no pull request, requests: %v", *requests)
+	}
+	if pullRequest.Number != 3 || pullRequest.Title != "[full] Fix login" || pullRequest.MergeCommitSha != "0123456789ab" {
+		t.Errorf("got %+v", pullRequest)
+	}
+	if want := []string{"full", "blue"}; !reflect.DeepEqual(pullRequest.Labels, want) {
+		t.Errorf("got labels %q, want %q", pullRequest.Labels, want)
+	}
+	if pullRequest.TitleHtml != "[full] Fix login" {
+		t.Errorf("got title html %q, want the escaped title without a rendered one", pullRequest.TitleHtml)
+	}
+}
+
+func TestFetchPullRequestBitbucketNotFound(t *testing.T) {
+	conf, _, _ := bitbucketServer(t, map[string]string{})
+	if pullRequest := fetchPullRequestBitbucket(conf, 4); pullRequest != nil {
+		t.Errorf("got %+v for an unknown pull request, want nil", pullRequest)
+	}
+	if pullRequest := fetchPullRequestForCommitBitbucket(conf, "0123456789abcdef"); pullRequest != nil {
+		t.Errorf("got %+v for an unknown commit, want nil", pullRequest)
+	}
+}
+
+func TestFetchPullRequestForCommitBitbucketFollowsPages(t *testing.T) {
+	conf, requests, _ := bitbucketServer(t, map[string]string{
+		"/commit/0123456789abcdef/pullrequests": `{"values": [{"id": 1, "merge_commit": {"hash": "fedcba987654"}}],
+			"next": "{server}/2.0/repositories/owner/repo/commit/0123456789abcdef/pullrequests?page=2"}`,
+		"/commit/0123456789abcdef/pullrequests?page=2": `{"values": [{"id": 2, "merge_commit": {"hash": "0123456789ab"}}]}`,
+		"/pullrequests/1": `{"id": 1, "title": "first"}`,
+		"/pullrequests/2": `{"id": 2, "title": "merged"}`,
+	})
+
+	pullRequest := fetchPullRequestForCommitBitbucket(conf, "0123456789abcdef")
+	if pullRequest == nil || pullRequest.Id != 2 {
+		t.Fatalf("got %+v, want pull request 2 with the abbreviated merge commit on the second page", pullRequest)
+	}
+	want := []string{"/commit/0123456789abcdef/pullrequests", "/commit/0123456789abcdef/pullrequests?page=2", "/pullrequests/2"}
+	if !reflect.DeepEqual(*requests, want) {
+		t.Errorf("got requests %v, want %v", *requests, want)
+	}
+}
+
+func TestFetchPullRequestForCommitBitbucketFallsBackToFirst(t *testing.T) {
+	conf, _, _ := bitbucketServer(t, map[string]string{
+		"/commit/0123456789abcdef/pullrequests": `{"values": [{"id": 5}, {"id": 6, "merge_commit": {"hash": "fedcba987654"}}]}`,
+		"/pullrequests/5":                       `{"id": 5, "title": "open"}`,
+	})
+
+	pullRequest := fetchPullRequestForCommitBitbucket(conf, "0123456789abcdef")
+	if pullRequest == nil || pullRequest.Id != 5 {
+		t.Errorf("got %+v, want the first pull request of the commit without a matching merge commit", pullRequest)
+	}
+}
+
+func TestRestRequestAuthentication(t *testing.T) {
+	conf, _, authorizations := bitbucketServer(t, map[string]string{"/pullrequests/3": `{"id": 3}`})
+
+	fetchPullRequestBitbucket(conf, 3)
+	conf.AuthToken = "user:app password"
+	fetchPullRequestBitbucket(conf, 3)
+
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("user", "app password")
+	want := []string{"Bearer token", request.Header.Get("Authorization")}
+	if !reflect.DeepEqual(*authorizations, want) {
+		t.Errorf("got authorizations %q, want bearer for a token and basic for username:app_password %q", *authorizations, want)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// default flavors for pull requests into the branches matching a glob, see ConfigDimension.BranchDefaults
+type BranchDefault struct {
+	Branch string
+	// the first label of each flavor, as FlavorDimension.DefaultFlavors
+	Labels []string
+}
+
+// the target branch of the pull request, or the target_branch input if there is no pull request
+func getTargetBranch(conf Conf, pullRequest *PullRequest) string {
+	if pullRequest != nil {
+		return pullRequest.TargetBranch
+	}
+	return conf.TargetBranch
+}
+
+// replaces the default flavors of each dimension by the first of its branch defaults matching the target branch
+func applyBranchDefaults(flavorDimensions map[int]FlavorDimension, targetBranch string) {
+	if len(targetBranch) == 0 {
+		return
+	}
+	for _, flavorDimension := range sortedFlavorDimensions(flavorDimensions) {
+		for _, branchDefault := range flavorDimension.BranchDefaults {
+			if matched, _ := path.Match(branchDefault.Branch, targetBranch); !matched {
+				continue
+			}
+			fmt.Printf("Target branch %s matches %s, default flavors of dimension %v are %s\n", targetBranch,
+				branchDefault.Branch, flavorDimension, strings.Join(flavorDimension.flavorNames(branchDefault.Labels), ", "))
+			flavorDimension.DefaultFlavors = branchDefault.Labels
+			flavorDimensions[flavorDimension.Index] = flavorDimension
+			break
+		}
+	}
+}
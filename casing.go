@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// case modifiers of flavor placeholders, e.g. "#1:kebab"
+var caseModifiers = map[string]func(string) string{
+	"raw":    func(name string) string { return name },
+	"lower":  func(name string) string { return strings.ToLower(strings.Join(splitWords(name), "")) },
+	"upper":  func(name string) string { return strings.ToUpper(strings.Join(splitWords(name), "_")) },
+	"kebab":  func(name string) string { return strings.ToLower(strings.Join(splitWords(name), "-")) },
+	"snake":  func(name string) string { return strings.ToLower(strings.Join(splitWords(name), "_")) },
+	"pascal": pascalCase,
+	"camel":  camelCase,
+}
+
+func caseModifierNames() []string {
+	return []string{"raw", "lower", "upper", "kebab", "snake", "pascal", "camel"}
+}
+
+/*
+splits a name into words at spaces, dashes, underscores, dots and camel case boundaries:
+"free trial", "free-trial", "free_trial" and "freeTrial" all yield [free trial]; "HTTPServer" yields [HTTP Server].
+An upper case letter after another one only starts a word if it is followed by lower case letters and the letters
+before it form a word of their own: "HDfull" stays one word.
+*/
+func splitWords(name string) []string {
+	var words []string
+	var word []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if r == ' ' || r == '-' || r == '_' || r == '.' || unicode.IsSpace(r) {
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(r) {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower && len(word) > 1) {
+				words = append(words, string(word))
+				word = nil
+			}
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+// upper-cases the first letter of every word and joins them. The case of the remaining letters is kept.
+func pascalCase(name string) string {
+	var result strings.Builder
+	for _, word := range splitWords(name) {
+		result.WriteString(capitalize(word))
+	}
+	return result.String()
+}
+
+// like pascalCase, but with the first word in lower case
+func camelCase(name string) string {
+	words := splitWords(name)
+	if len(words) == 0 {
+		return ""
+	}
+	var result strings.Builder
+	result.WriteString(strings.ToLower(words[0]))
+	for _, word := range words[1:] {
+		result.WriteString(capitalize(word))
+	}
+	return result.String()
+}
+
+// upper-cases the first letter
+func capitalize(name string) string {
+	runes := []rune(name)
+	if len(runes) == 0 {
+		return name
+	}
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
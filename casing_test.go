@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"full", []string{"full"}},
+		{"free trial", []string{"free", "trial"}},
+		{"free-trial", []string{"free", "trial"}},
+		{"free_trial", []string{"free", "trial"}},
+		{"free.trial", []string{"free", "trial"}},
+		{"freeTrial", []string{"free", "Trial"}},
+		{"FreeTrial", []string{"Free", "Trial"}},
+		{" free  -trial_ ", []string{"free", "trial"}},
+		{"HTTPServer", []string{"HTTP", "Server"}},
+		{"HDfull", []string{"HDfull"}},
+		{"QA", []string{"QA"}},
+		{"qaQA", []string{"qa", "QA"}},
+		{"v2Beta", []string{"v2", "Beta"}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		if got := splitWords(test.name); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCaseModifiers(t *testing.T) {
+	tests := []struct {
+		name string
+		want map[string]string
+	}{
+		{"free trial", map[string]string{
+			"raw": "free trial", "lower": "freetrial", "upper": "FREE_TRIAL", "kebab": "free-trial",
+			"snake": "free_trial", "pascal": "FreeTrial", "camel": "freeTrial",
+		}},
+		{"freeTrial", map[string]string{
+			"raw": "freeTrial", "lower": "freetrial", "upper": "FREE_TRIAL", "kebab": "free-trial",
+			"snake": "free_trial", "pascal": "FreeTrial", "camel": "freeTrial",
+		}},
+		{"HDfull", map[string]string{
+			"raw": "HDfull", "lower": "hdfull", "upper": "HDFULL", "kebab": "hdfull",
+			"snake": "hdfull", "pascal": "HDfull", "camel": "hdfull",
+		}},
+		{"HTTPServer", map[string]string{
+			"raw": "HTTPServer", "lower": "httpserver", "upper": "HTTP_SERVER", "kebab": "http-server",
+			"snake": "http_server", "pascal": "HTTPServer", "camel": "httpServer",
+		}},
+	}
+	for _, test := range tests {
+		for _, modifier := range caseModifierNames() {
+			if got := caseModifiers[modifier](test.name); got != test.want[modifier] {
+				t.Errorf("%v(%q) = %q, want %q", modifier, test.name, got, test.want[modifier])
+			}
+		}
+	}
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Config is the schema of the file at config_path, YAML or JSON:
+
+	dimensions:
+	  - name: tier
+	    flavors:
+	      - name: full
+	        labels: [full, paid]
+	      - name: demo
+	        default: true
+	  - name: color
+	    flavors: [orange, blue, teal]
+	outputs:
+	  - key: GRADLE_TASK
+	    pattern: "assemble#{tier}#{color}Release"
+	    separator: ","
+	labels2env:
+	  - label: "dist_*"
+	    key: DISTRIBUTE
+
+Each section replaces the respective input (variant_labels, variant_patterns, labels2env, label_implications) if
+present. The inputs are converted to the same structure, see getConfig.
+*/
+type Config struct {
+	Dimensions []ConfigDimension `yaml:"dimensions"`
+	Outputs    []ConfigOutput    `yaml:"outputs"`
+	Labels2Env []ConfigLabel2Env `yaml:"labels2env"`
+	// the label_implications input has no env
+	Implications []ConfigImplication `yaml:"implications"`
+	// has no input equivalent
+	Combinations ConfigCombinations `yaml:"combinations"`
+
+	// the file the config was read from, for error messages
+	Path string `yaml:"-"`
+}
+
+type ConfigDimension struct {
+	Name    string         `yaml:"name"`
+	Flavors []ConfigFlavor `yaml:"flavors"`
+	// how many flavors may be selected: "multi" (default), "single" or "at_least_one"
+	Select string `yaml:"select"`
+	// flavors in the order in which they win a conflict of a single-select dimension
+	Priority []string `yaml:"priority"`
+	// a label selecting all flavors, in addition to "<name>:*" of named dimensions
+	SelectAll string `yaml:"select_all"`
+	// label patterns naming the flavor by their first capture, see LabelMatcher
+	Match []string `yaml:"match"`
+	// default flavors by target branch glob, in order of precedence, overriding the default flavors
+	BranchDefaults ConfigBranchDefaults `yaml:"branch_defaults"`
+	Line           int                  `yaml:"-"`
+}
+
+// a flavor is either given by its name only or as a mapping
+type ConfigFlavor struct {
+	Name string `yaml:"name"`
+	// the labels selecting the flavor, defaults to its name
+	Labels  []string `yaml:"labels"`
+	Default bool     `yaml:"default"`
+	// globs of files selecting the flavor if changed, see PathRule
+	Paths []string `yaml:"paths"`
+	Line  int      `yaml:"-"`
+}
+
+// the mapping "{branch glob}: {flavor or list of flavors}" in the order of the file
+type ConfigBranchDefaults []ConfigBranchDefault
+
+type ConfigBranchDefault struct {
+	Branch  string
+	Flavors []string
+	Line    int
+}
+
+type ConfigOutput struct {
+	Key       string `yaml:"key"`
+	Pattern   string `yaml:"pattern"`
+	Separator string `yaml:"separator"`
+	Line      int    `yaml:"-"`
+	// where the output was declared, for errors in the pattern
+	Source string `yaml:"-"`
+}
+
+/*
+rules restricting the flavor combinations: if there are include rules, only variants matching one of them are
+built. Variants matching an exclude rule are not built.
+*/
+type ConfigCombinations struct {
+	Include []ConfigCombination `yaml:"include"`
+	Exclude []ConfigCombination `yaml:"exclude"`
+}
+
+// a rule matches variants with one of the given flavors for every dimension of the rule, by dimension name or index
+type ConfigCombination struct {
+	Flavors map[string][]string `yaml:"-"`
+	Line    int                 `yaml:"-"`
+	Source  string              `yaml:"-"`
+}
+
+// see label2Env for the semantics of the fields
+type ConfigLabel2Env struct {
+	Label string `yaml:"label"`
+	Key   string `yaml:"key"`
+	Value string `yaml:"value"`
+	Line  int    `yaml:"-"`
+}
+
+// if Label is set, the Implies labels are added before flavors are selected and Env is exported. Label may be a
+// pattern, see LabelMatcher.
+type ConfigImplication struct {
+	Label   string            `yaml:"label"`
+	Implies []string          `yaml:"implies"`
+	Env     map[string]string `yaml:"env"`
+	Line    int               `yaml:"-"`
+	Source  string            `yaml:"-"`
+}
+
+func (dimension *ConfigDimension) UnmarshalYAML(node *yaml.Node) error {
+	type plain ConfigDimension
+	dimension.Line = node.Line
+	return decodeStrict(node, (*plain)(dimension), "name", "flavors", "select", "priority", "select_all", "match",
+		"branch_defaults")
+}
+
+func (flavor *ConfigFlavor) UnmarshalYAML(node *yaml.Node) error {
+	type plain ConfigFlavor
+	flavor.Line = node.Line
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&flavor.Name)
+	}
+	return decodeStrict(node, (*plain)(flavor), "name", "labels", "default", "paths")
+}
+
+func (branchDefaults *ConfigBranchDefaults) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of target branch globs to flavors", node.Line)
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		branchDefault := ConfigBranchDefault{Branch: key.Value, Line: key.Line}
+		if value.Kind == yaml.ScalarNode {
+			branchDefault.Flavors = []string{value.Value}
+		} else if err := value.Decode(&branchDefault.Flavors); err != nil {
+			return fmt.Errorf("line %d: expected a flavor or a list of flavors for target branch %v", value.Line, key.Value)
+		}
+		*branchDefaults = append(*branchDefaults, branchDefault)
+	}
+	return nil
+}
+
+func (output *ConfigOutput) UnmarshalYAML(node *yaml.Node) error {
+	type plain ConfigOutput
+	output.Line = node.Line
+	return decodeStrict(node, (*plain)(output), "key", "pattern", "separator")
+}
+
+func (rule *ConfigLabel2Env) UnmarshalYAML(node *yaml.Node) error {
+	type plain ConfigLabel2Env
+	rule.Line = node.Line
+	return decodeStrict(node, (*plain)(rule), "label", "key", "value")
+}
+
+func (implication *ConfigImplication) UnmarshalYAML(node *yaml.Node) error {
+	type plain ConfigImplication
+	implication.Line = node.Line
+	return decodeStrict(node, (*plain)(implication), "label", "implies", "env")
+}
+
+func (combination *ConfigCombination) UnmarshalYAML(node *yaml.Node) error {
+	combination.Line = node.Line
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of dimensions to flavors", node.Line)
+	}
+	combination.Flavors = make(map[string][]string)
+	for i := 0; i < len(node.Content); i += 2 {
+		var flavors []string
+		value := node.Content[i+1]
+		if value.Kind == yaml.ScalarNode {
+			flavors = []string{value.Value}
+		} else if err := value.Decode(&flavors); err != nil {
+			return err
+		}
+		combination.Flavors[node.Content[i].Value] = flavors
+	}
+	return nil
+}
+
+// decodes a mapping, rejecting fields other than the given ones. Unlike the decoder, yaml.Node.Decode has no option
+// for this.
+func decodeStrict(node *yaml.Node, value interface{}, fields ...string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping with the fields %v", node.Line, strings.Join(fields, ", "))
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		known := false
+		for _, field := range fields {
+			known = known || key.Value == field
+		}
+		if !known {
+			return fmt.Errorf("line %d: unknown field %v, expected one of %v", key.Line, key.Value, strings.Join(fields, ", "))
+		}
+	}
+	return node.Decode(value)
+}
+
+var configNameRegex = regexp.MustCompile(`^[A-Za-z_][\w-]*$`)
+
+/*
+the configuration from, in increasing precedence:
+ 1. the inputs variant_labels, variant_patterns, labels2env and label_implications
+ 2. the file at config_path
+ 3. the file at repo_config_path in the repository, see loadRepoConfig
+*/
+func getConfig(conf Conf) Config {
+	validatePatternEngine(conf)
+	validatePathSelection(conf)
+	config := Config{Labels2Env: parseLabels2Env(conf), Implications: parseLabelImplications(conf)}
+	if len(conf.VariantLabels) > 0 {
+		config.Dimensions = parseVariantLabels(conf)
+	}
+	if len(conf.VariantPatterns) > 0 {
+		config.Outputs = parseVariantPatterns(conf)
+	}
+	if len(conf.ConfigPath) > 0 {
+		content, err := ioutil.ReadFile(conf.ConfigPath)
+		if err != nil {
+			fail("Failed to read config file: %v", err)
+		}
+		fileConfig, err := parseConfig(conf.ConfigPath, content)
+		if err != nil {
+			fail("%v", err)
+		}
+		config.override(fileConfig)
+	}
+	if conf.RepoConfigMode != "" && conf.RepoConfigMode != "override" && conf.RepoConfigMode != "merge" {
+		fail("Invalid repo config mode: %v. Allowed are: override, merge", conf.RepoConfigMode)
+	}
+	if repoConfig := loadRepoConfig(conf); repoConfig != nil {
+		if conf.RepoConfigMode == "merge" {
+			config.merge(*repoConfig)
+		} else {
+			config.override(*repoConfig)
+		}
+	}
+	if len(config.Dimensions) == 0 {
+		fail("No flavors configured: set variant labels or dimensions in the config file")
+	}
+	if len(config.Outputs) == 0 {
+		fail("No variant patterns configured: set variant patterns or outputs in the config file")
+	}
+	return config
+}
+
+// parses and validates a config file
+func parseConfig(path string, content []byte) (Config, error) {
+	config := Config{Path: path}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return config, fmt.Errorf("Invalid config file %v: %v", path, err)
+	}
+	for i := range config.Outputs {
+		config.Outputs[i].Source = fmt.Sprintf("%v:%d", path, config.Outputs[i].Line)
+	}
+	for i := range config.Implications {
+		config.Implications[i].Source = fmt.Sprintf("%v:%d", path, config.Implications[i].Line)
+	}
+	for _, combinations := range [][]ConfigCombination{config.Combinations.Include, config.Combinations.Exclude} {
+		for i := range combinations {
+			combinations[i].Source = fmt.Sprintf("%v:%d", path, combinations[i].Line)
+		}
+	}
+	return config, config.validate()
+}
+
+// replaces the sections that are present in the other config
+func (config *Config) override(other Config) {
+	if len(other.Dimensions) > 0 {
+		config.Dimensions = other.Dimensions
+	}
+	if len(other.Outputs) > 0 {
+		config.Outputs = other.Outputs
+	}
+	if len(other.Labels2Env) > 0 {
+		config.Labels2Env = other.Labels2Env
+	}
+	if len(other.Implications) > 0 {
+		config.Implications = other.Implications
+	}
+	if len(other.Combinations.Include) > 0 || len(other.Combinations.Exclude) > 0 {
+		config.Combinations = other.Combinations
+	}
+}
+
+/*
+merges the other config into this one:
+  - dimensions with the same name, or unnamed ones at the same position, are merged: flavors with the same name are
+    replaced, new ones added, select, priority, select_all and branch_defaults replaced if set, match patterns
+    added. Other dimensions are added.
+  - outputs with the same key are replaced, others added
+  - labels2env rules and implications for the same label are replaced, others added
+  - combination rules are added
+*/
+func (config *Config) merge(other Config) {
+	for i, dimension := range other.Dimensions {
+		target := -1
+		for j, existing := range config.Dimensions {
+			if existing.Name == dimension.Name && (len(existing.Name) > 0 || i == j) {
+				target = j
+				break
+			}
+		}
+		if target < 0 {
+			config.Dimensions = append(config.Dimensions, dimension)
+			continue
+		}
+		config.Dimensions[target].mergeFlavors(dimension.Flavors)
+		if len(dimension.Select) > 0 {
+			config.Dimensions[target].Select = dimension.Select
+		}
+		if len(dimension.Priority) > 0 {
+			config.Dimensions[target].Priority = dimension.Priority
+		}
+		if len(dimension.SelectAll) > 0 {
+			config.Dimensions[target].SelectAll = dimension.SelectAll
+		}
+		config.Dimensions[target].Match = append(config.Dimensions[target].Match, dimension.Match...)
+		if len(dimension.BranchDefaults) > 0 {
+			config.Dimensions[target].BranchDefaults = dimension.BranchDefaults
+		}
+	}
+	for _, output := range other.Outputs {
+		replaced := false
+		for j, existing := range config.Outputs {
+			if existing.Key == output.Key {
+				config.Outputs[j] = output
+				replaced = true
+			}
+		}
+		if !replaced {
+			config.Outputs = append(config.Outputs, output)
+		}
+	}
+	for _, rule := range other.Labels2Env {
+		replaced := false
+		for j, existing := range config.Labels2Env {
+			if existing.Label == rule.Label {
+				config.Labels2Env[j] = rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			config.Labels2Env = append(config.Labels2Env, rule)
+		}
+	}
+	for _, implication := range other.Implications {
+		replaced := false
+		for j, existing := range config.Implications {
+			if existing.Label == implication.Label {
+				config.Implications[j] = implication
+				replaced = true
+			}
+		}
+		if !replaced {
+			config.Implications = append(config.Implications, implication)
+		}
+	}
+	config.Combinations.Include = append(config.Combinations.Include, other.Combinations.Include...)
+	config.Combinations.Exclude = append(config.Combinations.Exclude, other.Combinations.Exclude...)
+}
+
+// flavors with the same name are replaced, others added. Defaults of the other flavors replace the existing ones.
+func (dimension *ConfigDimension) mergeFlavors(flavors []ConfigFlavor) {
+	for _, flavor := range flavors {
+		if flavor.Default {
+			for i := range dimension.Flavors {
+				dimension.Flavors[i].Default = false
+			}
+			break
+		}
+	}
+	for _, flavor := range flavors {
+		replaced := false
+		for i, existing := range dimension.Flavors {
+			if existing.Name == flavor.Name {
+				dimension.Flavors[i] = flavor
+				replaced = true
+			}
+		}
+		if !replaced {
+			dimension.Flavors = append(dimension.Flavors, flavor)
+		}
+	}
+}
+
+// an error at the line of the config file
+func (config Config) errorf(line int, message string, args ...interface{}) error {
+	return fmt.Errorf("%v:%d: %v", config.Path, line, fmt.Sprintf(message, args...))
+}
+
+func (config Config) validate() error {
+	dimensionNames := make(map[string]bool)
+	labels := make(map[string]bool)
+	for _, dimension := range config.Dimensions {
+		if len(dimension.Name) > 0 {
+			if !configNameRegex.MatchString(dimension.Name) {
+				return config.errorf(dimension.Line, "invalid dimension name %v: use letters, digits, '_' and '-'", dimension.Name)
+			}
+			if dimensionNames[dimension.Name] {
+				return config.errorf(dimension.Line, "duplicate dimension name %v", dimension.Name)
+			}
+			dimensionNames[dimension.Name] = true
+		}
+		if len(dimension.Flavors) == 0 {
+			return config.errorf(dimension.Line, "dimension %v has no flavors", dimension.Name)
+		}
+		switch dimension.Select {
+		case "", selectMulti, selectSingle, selectAtLeastOne:
+		default:
+			return config.errorf(dimension.Line, "invalid select %v of dimension %v. Allowed are: %v, %v, %v", dimension.Select,
+				dimension.Name, selectMulti, selectSingle, selectAtLeastOne)
+		}
+		if len(dimension.SelectAll) > 0 && dimension.Select == selectSingle {
+			return config.errorf(dimension.Line, "dimension %v allows a single flavor only and cannot have a select_all label", dimension.Name)
+		}
+		for _, spec := range dimension.Match {
+			if !isLabelPattern(spec) {
+				return config.errorf(dimension.Line, "%v in match of dimension %v is not a label pattern", spec, dimension.Name)
+			}
+			if _, err := newLabelMatcher(spec, "", false); err != nil {
+				return config.errorf(dimension.Line, "%v", err)
+			}
+		}
+		for _, branchDefault := range dimension.BranchDefaults {
+			if _, err := path.Match(branchDefault.Branch, ""); err != nil {
+				return config.errorf(branchDefault.Line, "invalid target branch glob %v: %v", branchDefault.Branch, err)
+			}
+			if len(branchDefault.Flavors) > 1 && dimension.Select == selectSingle {
+				return config.errorf(branchDefault.Line, "dimension %v allows a single flavor only and cannot have more than one default for target branch %v",
+					dimension.Name, branchDefault.Branch)
+			}
+			for _, flavor := range branchDefault.Flavors {
+				if !dimension.hasFlavor(flavor) {
+					return config.errorf(branchDefault.Line, "default %v for target branch %v is not a flavor of dimension %v",
+						flavor, branchDefault.Branch, dimension.Name)
+				}
+			}
+		}
+		for _, name := range dimension.Priority {
+			if !dimension.hasFlavor(name) {
+				return config.errorf(dimension.Line, "priority of dimension %v lists %v, which is not one of its flavors", dimension.Name, name)
+			}
+		}
+		hasDefault := false
+		for _, flavor := range dimension.Flavors {
+			if len(flavor.Name) == 0 {
+				return config.errorf(flavor.Line, "flavor without name")
+			}
+			if flavor.Default {
+				if hasDefault && dimension.Select == selectSingle {
+					return config.errorf(flavor.Line, "dimension %v allows a single flavor only and cannot have more than one default flavor", dimension.Name)
+				}
+				hasDefault = true
+			}
+			for _, glob := range flavor.Paths {
+				if _, err := newPathRule(glob, ""); err != nil {
+					return config.errorf(flavor.Line, "%v", err)
+				}
+			}
+			for _, label := range flavor.labels() {
+				if len(label) == 0 {
+					return config.errorf(flavor.Line, "empty label for flavor %v", flavor.Name)
+				}
+				if _, err := newLabelMatcher(label, label, false); isLabelPattern(label) && err != nil {
+					return config.errorf(flavor.Line, "%v", err)
+				}
+				if labels[label] {
+					return config.errorf(flavor.Line, "label %v is used for more than one flavor", label)
+				}
+				labels[label] = true
+			}
+		}
+	}
+	for _, dimension := range config.Dimensions {
+		if labels[dimension.SelectAll] {
+			return config.errorf(dimension.Line, "select_all label %v of dimension %v is also a flavor label", dimension.SelectAll, dimension.Name)
+		}
+	}
+	for _, output := range config.Outputs {
+		if len(output.Key) == 0 {
+			return config.errorf(output.Line, "output without key")
+		}
+		if len(output.Pattern) == 0 {
+			return config.errorf(output.Line, "output %v without pattern", output.Key)
+		}
+	}
+	for _, rule := range config.Labels2Env {
+		if len(rule.Label) == 0 {
+			return config.errorf(rule.Line, "labels2env rule without label")
+		}
+	}
+	for _, implication := range config.Implications {
+		if len(implication.Label) == 0 {
+			return config.errorf(implication.Line, "implication without label")
+		}
+		if len(implication.Implies) == 0 && len(implication.Env) == 0 {
+			return config.errorf(implication.Line, "implication for %v without implies or env", implication.Label)
+		}
+		if _, err := newLabelMatcher(implication.Label, implication.Label, false); isLabelPattern(implication.Label) && err != nil {
+			return config.errorf(implication.Line, "%v", err)
+		}
+	}
+	return nil
+}
+
+func (dimension ConfigDimension) hasFlavor(name string) bool {
+	for _, flavor := range dimension.Flavors {
+		if flavor.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (flavor ConfigFlavor) labels() []string {
+	if len(flavor.Labels) == 0 {
+		return []string{flavor.Name}
+	}
+	return flavor.Labels
+}
+
+// the dimensions by index, starting at 1
+func (config Config) getFlavorDimensions(conf Conf) map[int]FlavorDimension {
+	flavorDimensions := make(map[int]FlavorDimension)
+	for i, dimension := range config.Dimensions {
+		flavorDimension := FlavorDimension{
+			Index:           i + 1,
+			Name:            dimension.Name,
+			Flavors:         make(map[string]string),
+			Select:          dimension.Select,
+			Priority:        dimension.Priority,
+			SelectedFlavors: make(map[string]bool),
+			NegatedFlavors:  make(map[string]bool),
+		}
+		if len(dimension.Name) > 0 && dimension.Select != selectSingle {
+			flavorDimension.SelectAllLabels = append(flavorDimension.SelectAllLabels, dimension.Name+":*")
+		}
+		if len(dimension.SelectAll) > 0 {
+			flavorDimension.SelectAllLabels = append(flavorDimension.SelectAllLabels, dimension.SelectAll)
+		}
+		for _, flavor := range dimension.Flavors {
+			for _, label := range flavor.labels() {
+				flavorDimension.Flavors[label] = flavor.Name
+				flavorDimension.Labels = append(flavorDimension.Labels, label)
+				if isLabelPattern(label) {
+					flavorDimension.Matchers = append(flavorDimension.Matchers, newConfigLabelMatcher(label, label, conf))
+				}
+			}
+			if flavor.Default {
+				flavorDimension.DefaultFlavors = append(flavorDimension.DefaultFlavors, flavor.labels()[0])
+			}
+			for _, glob := range flavor.Paths {
+				rule, err := newPathRule(glob, flavor.labels()[0])
+				if err != nil {
+					fail("%v", err)
+				}
+				flavorDimension.PathRules = append(flavorDimension.PathRules, rule)
+			}
+		}
+		for _, spec := range dimension.Match {
+			flavorDimension.Matchers = append(flavorDimension.Matchers, newConfigLabelMatcher(spec, "", conf))
+		}
+		if len(flavorDimension.DefaultFlavors) > 1 && flavorDimension.Select == selectSingle {
+			fail("Flavor dimension %v allows a single flavor only, but has the defaults %v", flavorDimension,
+				strings.Join(flavorDimension.flavorNames(flavorDimension.DefaultFlavors), ", "))
+		}
+		for _, branchDefault := range dimension.BranchDefaults {
+			target := BranchDefault{Branch: branchDefault.Branch}
+			for _, name := range branchDefault.Flavors {
+				for _, flavor := range dimension.Flavors {
+					if flavor.Name == name {
+						target.Labels = append(target.Labels, flavor.labels()[0])
+					}
+				}
+			}
+			flavorDimension.BranchDefaults = append(flavorDimension.BranchDefaults, target)
+		}
+		flavorDimensions[flavorDimension.Index] = flavorDimension
+	}
+	return flavorDimensions
+}
+
+func newConfigLabelMatcher(spec string, label string, conf Conf) LabelMatcher {
+	matcher, err := newLabelMatcher(spec, label, conf.IgnoreLabelCase)
+	if err != nil {
+		fail("%v", err)
+	}
+	return matcher
+}
+
+func (config Config) getVariantPatterns(conf Conf, flavorDimensions map[int]FlavorDimension) []VariantPattern {
+	var variantPatterns []VariantPattern
+	for _, output := range config.Outputs {
+		separator := output.Separator
+		if len(separator) == 0 {
+			separator = " "
+		}
+		variantPatterns = append(variantPatterns,
+			newVariantPattern(conf, output.Key, output.Pattern, separator, output.Source, flavorDimensions))
+	}
+	return variantPatterns
+}
+
+func (config Config) getLabel2EnvRules() []Label2EnvRule {
+	var rules []Label2EnvRule
+	for _, rule := range config.Labels2Env {
+		rules = append(rules, newLabel2EnvRule(rule.Label, rule.Key, rule.Value))
+	}
+	return rules
+}
+
+// resolves the dimensions and flavors of the combination rules
+func (config Config) getCombinationRules(flavorDimensions map[int]FlavorDimension) (include []CombinationRule, exclude []CombinationRule) {
+	for _, combination := range config.Combinations.Include {
+		include = append(include, newCombinationRule(combination, flavorDimensions))
+	}
+	for _, combination := range config.Combinations.Exclude {
+		exclude = append(exclude, newCombinationRule(combination, flavorDimensions))
+	}
+	return include, exclude
+}
+
+func newCombinationRule(combination ConfigCombination, flavorDimensions map[int]FlavorDimension) CombinationRule {
+	rule := CombinationRule{Flavors: make(map[int][]string), Source: combination.Source}
+	if len(combination.Flavors) == 0 {
+		fail("%v: empty combination rule", combination.Source)
+	}
+	for reference, flavors := range combination.Flavors {
+		dimension := findFlavorDimension(reference, flavorDimensions)
+		if dimension == 0 {
+			fail("%v: combination rule references undefined flavor dimension %v", combination.Source, reference)
+		}
+		for _, flavor := range flavors {
+			if !flavorDimensions[dimension].hasFlavor(flavor) {
+				fail("%v: %v is not a flavor of dimension %v", combination.Source, flavor, flavorDimensions[dimension])
+			}
+		}
+		rule.Flavors[dimension] = flavors
+	}
+	return rule
+}
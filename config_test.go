@@ -0,0 +1,244 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfigYAML = `dimensions:
+  - name: tier
+    select: single
+    flavors:
+      - name: full
+        labels: [full, paid]
+      - name: demo
+        default: true
+  - name: color
+    flavors: [orange, blue]
+    branch_defaults:
+      release/*: blue
+      main: [orange, blue]
+outputs:
+  - key: GRADLE_TASK
+    pattern: "assemble#{tier}#{color}Release"
+    separator: ","
+labels2env:
+  - label: "dist_*"
+    key: DISTRIBUTE
+implications:
+  - label: release-candidate
+    implies: [full]
+    env: {TRACK: beta}
+combinations:
+  exclude:
+    - tier: demo
+      color: [blue]
+`
+
+const testConfigJSON = `{
+  "dimensions": [
+    {"name": "tier", "select": "single", "flavors": [
+      {"name": "full", "labels": ["full", "paid"]},
+      {"name": "demo", "default": true}
+    ]},
+    {"name": "color", "flavors": ["orange", "blue"], "branch_defaults": {"release/*": "blue", "main": ["orange", "blue"]}}
+  ],
+  "outputs": [{"key": "GRADLE_TASK", "pattern": "assemble#{tier}#{color}Release", "separator": ","}],
+  "labels2env": [{"label": "dist_*", "key": "DISTRIBUTE"}],
+  "implications": [{"label": "release-candidate", "implies": ["full"], "env": {"TRACK": "beta"}}],
+  "combinations": {"exclude": [{"tier": "demo", "color": ["blue"]}]}
+}`
+
+func TestParseConfig(t *testing.T) {
+	for _, test := range []struct{ path, content string }{
+		{"config.yml", testConfigYAML},
+		{"config.json", testConfigJSON},
+	} {
+		config, err := parseConfig(test.path, []byte(test.content))
+		if err != nil {
+			t.Fatalf("%v: %v", test.path, err)
+		}
+		if len(config.Dimensions) != 2 {
+			t.Fatalf("%v: got %d dimensions, want 2", test.path, len(config.Dimensions))
+		}
+		tier, color := config.Dimensions[0], config.Dimensions[1]
+		if tier.Name != "tier" || tier.Select != selectSingle || len(tier.Flavors) != 2 {
+			t.Errorf("%v: got dimension %+v, want the single-select tier with 2 flavors", test.path, tier)
+		}
+		if full := tier.Flavors[0]; full.Name != "full" || !reflect.DeepEqual(full.labels(), []string{"full", "paid"}) || full.Default {
+			t.Errorf("%v: got flavor %+v, want full with the labels full and paid", test.path, full)
+		}
+		if demo := tier.Flavors[1]; demo.Name != "demo" || !reflect.DeepEqual(demo.labels(), []string{"demo"}) || !demo.Default {
+			t.Errorf("%v: got flavor %+v, want the default demo labeled by its name", test.path, demo)
+		}
+		var colors []string
+		for _, flavor := range color.Flavors {
+			colors = append(colors, flavor.Name)
+		}
+		if !reflect.DeepEqual(colors, []string{"orange", "blue"}) {
+			t.Errorf("%v: got color flavors %v, want orange and blue", test.path, colors)
+		}
+		if len(color.BranchDefaults) != 2 ||
+			color.BranchDefaults[0].Branch != "release/*" || !reflect.DeepEqual(color.BranchDefaults[0].Flavors, []string{"blue"}) ||
+			color.BranchDefaults[1].Branch != "main" || !reflect.DeepEqual(color.BranchDefaults[1].Flavors, []string{"orange", "blue"}) {
+			t.Errorf("%v: got branch defaults %+v, want release/* and main in file order", test.path, color.BranchDefaults)
+		}
+		if len(config.Outputs) != 1 || config.Outputs[0].Key != "GRADLE_TASK" || config.Outputs[0].Separator != "," {
+			t.Errorf("%v: got outputs %+v", test.path, config.Outputs)
+		}
+		if len(config.Labels2Env) != 1 || config.Labels2Env[0].Label != "dist_*" || config.Labels2Env[0].Key != "DISTRIBUTE" {
+			t.Errorf("%v: got labels2env %+v", test.path, config.Labels2Env)
+		}
+		if len(config.Implications) != 1 || !reflect.DeepEqual(config.Implications[0].Implies, []string{"full"}) ||
+			config.Implications[0].Env["TRACK"] != "beta" {
+			t.Errorf("%v: got implications %+v", test.path, config.Implications)
+		}
+		exclude := config.Combinations.Exclude
+		if len(exclude) != 1 || !reflect.DeepEqual(exclude[0].Flavors, map[string][]string{"tier": {"demo"}, "color": {"blue"}}) {
+			t.Errorf("%v: got exclude rules %+v", test.path, exclude)
+		}
+	}
+}
+
+func TestParseConfigSources(t *testing.T) {
+	config, err := parseConfig("config.yml", []byte(testConfigYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := config.Outputs[0].Source; source != "config.yml:15" {
+		t.Errorf("got output source %v, want config.yml:15", source)
+	}
+	if source := config.Implications[0].Source; source != "config.yml:22" {
+		t.Errorf("got implication source %v, want config.yml:22", source)
+	}
+	if source := config.Combinations.Exclude[0].Source; source != "config.yml:27" {
+		t.Errorf("got combination source %v, want config.yml:27", source)
+	}
+	if line := config.Dimensions[0].Flavors[1].Line; line != 7 {
+		t.Errorf("got flavor line %d, want 7", line)
+	}
+}
+
+func TestParseConfigEmpty(t *testing.T) {
+	config, err := parseConfig("config.yml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Dimensions) != 0 || len(config.Outputs) != 0 {
+		t.Errorf("got %+v, want an empty config", config)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown section", "dimensions: []\nvariants: []\n", "line 2: field variants not found"},
+		{"unknown dimension field", "dimensions:\n  - name: tier\n    flavours: [full]\n", "line 3: unknown field flavours"},
+		{"unknown flavor field", "dimensions:\n  - flavors:\n      - name: full\n        label: paid\n", "line 4: unknown field label"},
+		{"unknown output field", "outputs:\n  - key: A\n    patern: \"#1\"\n", "line 3: unknown field patern"},
+		{"unknown json field", `{"outputs": [{"key": "A", "pattern": "#1", "sep": ","}]}`, "line 1: unknown field sep"},
+		{"flavors not a list", "dimensions:\n  - name: tier\n    flavors: full\n", "line 3"},
+		{"branch defaults not a mapping", "dimensions:\n  - flavors: [full]\n    branch_defaults: [main]\n", "line 3: expected a mapping"},
+		{"dimension without flavors", "dimensions:\n  - name: tier\n", "config.yml:2: dimension tier has no flavors"},
+		{"invalid dimension name", "dimensions:\n  - name: \"a b\"\n    flavors: [full]\n", "config.yml:2: invalid dimension name a b"},
+		{"duplicate dimension", "dimensions:\n  - name: tier\n    flavors: [full]\n  - name: tier\n    flavors: [demo]\n",
+			"config.yml:4: duplicate dimension name tier"},
+		{"duplicate label", "dimensions:\n  - flavors: [full]\n  - flavors:\n      - name: paid\n        labels: [full]\n",
+			"config.yml:4: label full is used for more than one flavor"},
+		{"flavor without name", "dimensions:\n  - flavors:\n      - labels: [full]\n", "config.yml:3: flavor without name"},
+		{"invalid select", "dimensions:\n  - flavors: [full]\n    select: one\n", "config.yml:2: invalid select one"},
+		{"single select with two defaults", "dimensions:\n  - select: single\n    flavors:\n      - name: full\n        default: true\n      - name: demo\n        default: true\n",
+			"config.yml:6: dimension  allows a single flavor only"},
+		{"unknown priority", "dimensions:\n  - flavors: [full]\n    priority: [demo]\n", "config.yml:2: priority of dimension  lists demo"},
+		{"unknown branch default", "dimensions:\n  - flavors: [full]\n    branch_defaults:\n      main: demo\n",
+			"config.yml:4: default demo for target branch main is not a flavor"},
+		{"output without pattern", "outputs:\n  - key: A\n", "config.yml:2: output A without pattern"},
+		{"implication without implies", "implications:\n  - label: rc\n", "config.yml:2: implication for rc without implies or env"},
+	}
+	for _, test := range tests {
+		_, err := parseConfig("config.yml", []byte(test.content))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: got error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestConfigOverride(t *testing.T) {
+	config := Config{
+		Dimensions: []ConfigDimension{{Name: "tier", Flavors: []ConfigFlavor{{Name: "full"}}}},
+		Outputs:    []ConfigOutput{{Key: "A", Pattern: "#1"}},
+		Labels2Env: []ConfigLabel2Env{{Label: "x"}},
+	}
+	config.override(Config{
+		Outputs:      []ConfigOutput{{Key: "B", Pattern: "#1"}},
+		Implications: []ConfigImplication{{Label: "rc", Implies: []string{"full"}}},
+	})
+	if len(config.Dimensions) != 1 || len(config.Labels2Env) != 1 {
+		t.Errorf("got %+v, want the sections missing in the other config kept", config)
+	}
+	if len(config.Outputs) != 1 || config.Outputs[0].Key != "B" {
+		t.Errorf("got outputs %+v, want them replaced", config.Outputs)
+	}
+	if len(config.Implications) != 1 {
+		t.Errorf("got implications %+v, want the ones of the other config", config.Implications)
+	}
+}
+
+func TestConfigMerge(t *testing.T) {
+	config := Config{
+		Dimensions: []ConfigDimension{
+			{Name: "tier", Select: selectMulti, Match: []string{"tier/*"}, Flavors: []ConfigFlavor{
+				{Name: "full", Labels: []string{"full"}}, {Name: "demo", Default: true}}},
+			{Flavors: []ConfigFlavor{{Name: "orange"}}},
+		},
+		Outputs:      []ConfigOutput{{Key: "A", Pattern: "#1"}, {Key: "B", Pattern: "#2"}},
+		Labels2Env:   []ConfigLabel2Env{{Label: "x", Key: "X"}},
+		Implications: []ConfigImplication{{Label: "rc", Implies: []string{"full"}}},
+		Combinations: ConfigCombinations{Exclude: []ConfigCombination{{Source: "inputs"}}},
+	}
+	config.merge(Config{
+		Dimensions: []ConfigDimension{
+			{Name: "tier", Select: selectSingle, Match: []string{"t/*"}, Flavors: []ConfigFlavor{
+				{Name: "full", Labels: []string{"full", "paid"}, Default: true}, {Name: "lite"}}},
+			{Flavors: []ConfigFlavor{{Name: "blue"}}},
+			{Name: "store", Flavors: []ConfigFlavor{{Name: "play"}}},
+		},
+		Outputs:      []ConfigOutput{{Key: "B", Pattern: "#1#2"}, {Key: "C", Pattern: "#1"}},
+		Labels2Env:   []ConfigLabel2Env{{Label: "x", Key: "Y"}, {Label: "z", Key: "Z"}},
+		Implications: []ConfigImplication{{Label: "rc", Implies: []string{"lite"}}},
+		Combinations: ConfigCombinations{Exclude: []ConfigCombination{{Source: "repo"}}},
+	})
+
+	if len(config.Dimensions) != 3 || config.Dimensions[2].Name != "store" {
+		t.Fatalf("got dimensions %+v, want tier, the unnamed one and store", config.Dimensions)
+	}
+	tier := config.Dimensions[0]
+	if tier.Select != selectSingle || !reflect.DeepEqual(tier.Match, []string{"tier/*", "t/*"}) {
+		t.Errorf("got tier %+v, want select replaced and match patterns added", tier)
+	}
+	wantTier := []ConfigFlavor{{Name: "full", Labels: []string{"full", "paid"}, Default: true}, {Name: "demo"}, {Name: "lite"}}
+	if !reflect.DeepEqual(tier.Flavors, wantTier) {
+		t.Errorf("got tier flavors %+v, want %+v", tier.Flavors, wantTier)
+	}
+	if want := []ConfigFlavor{{Name: "orange"}, {Name: "blue"}}; !reflect.DeepEqual(config.Dimensions[1].Flavors, want) {
+		t.Errorf("got flavors %+v of the unnamed dimension, want %+v", config.Dimensions[1].Flavors, want)
+	}
+	wantOutputs := []ConfigOutput{{Key: "A", Pattern: "#1"}, {Key: "B", Pattern: "#1#2"}, {Key: "C", Pattern: "#1"}}
+	if !reflect.DeepEqual(config.Outputs, wantOutputs) {
+		t.Errorf("got outputs %+v, want %+v", config.Outputs, wantOutputs)
+	}
+	wantLabels2Env := []ConfigLabel2Env{{Label: "x", Key: "Y"}, {Label: "z", Key: "Z"}}
+	if !reflect.DeepEqual(config.Labels2Env, wantLabels2Env) {
+		t.Errorf("got labels2env %+v, want %+v", config.Labels2Env, wantLabels2Env)
+	}
+	if len(config.Implications) != 1 || !reflect.DeepEqual(config.Implications[0].Implies, []string{"lite"}) {
+		t.Errorf("got implications %+v, want rc replaced", config.Implications)
+	}
+	if exclude := config.Combinations.Exclude; len(exclude) != 2 || exclude[0].Source != "inputs" || exclude[1].Source != "repo" {
+		t.Errorf("got exclude rules %+v, want both added", exclude)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+)
+
+type PullRequestGithub struct {
+	Number      int    `json:"number"`
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	TitleHtml   string `json:"titleHTML"`
+	Body        string `json:"body"`
+	BodyHtml    string `json:"bodyHTML"`
+	MergeCommit struct {
+		Oid string `json:"oid"`
+	} `json:"mergeCommit"`
+	Author struct {
+		Login string `json:"login"`
+	} `json:"author"`
+	HeadRefName string `json:"headRefName"`
+	BaseRefName string `json:"baseRefName"`
+	Milestone   struct {
+		Title string `json:"title"`
+	} `json:"milestone"`
+	Labels LabelConnectionGithub `json:"labels"`
+}
+
+type LabelConnectionGithub struct {
+	TotalCount int      `json:"totalCount"`
+	PageInfo   PageInfo `json:"pageInfo"`
+	Edges      []struct {
+		Node struct {
+			Name string `json:"name"`
+		} `json:"node"`
+	} `json:"edges"`
+}
+
+type LabelsGraphQLResponseGithub struct {
+	Data struct {
+		Repository struct {
+			PullRequest struct {
+				Labels LabelConnectionGithub `json:"labels"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	} `json:"data"`
+}
+
+type PRGraphQLResponseGithub struct {
+	Data struct {
+		Repository struct {
+			PullRequest *PullRequestGithub `json:"pullRequest"`
+		} `json:"repository"`
+	} `json:"data"`
+}
+
+type MergeGraphQLResponseGithub struct {
+	Data struct {
+		Repository struct {
+			Object struct {
+				PullRequests struct {
+					Edges []struct {
+						Node PullRequestGithub `json:"node"`
+					} `json:"edges"`
+				} `json:"associatedPullRequests"`
+			} `json:"object"`
+		} `json:"repository"`
+	} `json:"data"`
+}
+
+type githubLabelSource struct{}
+
+func init() {
+	registerLabelSource("github", githubLabelSource{})
+}
+
+func (githubLabelSource) Validate(conf Conf) error {
+	if len(conf.RepoName) == 0 {
+		return errors.New("Missing repo name argument")
+	}
+	if len(conf.RepoOwner) == 0 {
+		return errors.New("Missing repo owner argument")
+	}
+	return nil
+}
+
+func (githubLabelSource) FetchPullRequest(conf Conf, number int) *PullRequest {
+	query := `
+		query($owner: String!, $name: String!, $number: Int!) {
+			repository(owner: $owner, name: $name) {
+				pullRequest(number: $number) {
+					number
+					url
+					title
+					titleHTML
+					body
+					bodyHTML
+					mergeCommit {
+						oid
+					}
+					author {
+						login
+					}
+					headRefName
+					baseRefName
+					milestone {
+						title
+					}
+					labels(first: 100) {
+						totalCount
+						pageInfo {
+							hasNextPage
+							endCursor
+						}
+						edges {
+							node {
+								name
+							}
+						}
+					}
+				}
+			}
+		}`
+	variables := map[string]interface{}{
+		"owner":  conf.RepoOwner,
+		"name":   conf.RepoName,
+		"number": number,
+	}
+	var graphQLResponse PRGraphQLResponseGithub
+	graphQLRequest(query, variables, conf, &graphQLResponse)
+	pullRequest := graphQLResponse.Data.Repository.PullRequest
+	if pullRequest == nil {
+		return nil
+	}
+	pullRequest.Labels = fetchAllLabelsGithub(conf, pullRequest.Number, pullRequest.Labels)
+	return pullRequest.toPullRequest()
+}
+
+func (githubLabelSource) FetchPullRequestForCommit(conf Conf, commitHash string) *PullRequest {
+	query := `
+		query($owner: String!, $name: String!, $oid: GitObjectID!) {
+			repository(owner: $owner, name: $name) {
+				object(oid: $oid) {
+					... on Commit {
+						associatedPullRequests(last: 1) {
+							edges {
+								node {
+									number
+									url
+									title
+									titleHTML
+									body
+									bodyHTML
+									mergeCommit {
+										oid
+									}
+									author {
+										login
+									}
+									headRefName
+									baseRefName
+									milestone {
+										title
+									}
+									labels(first: 100) {
+										totalCount
+										pageInfo {
+											hasNextPage
+											endCursor
+										}
+										edges {
+											node {
+												name
+											}
+										}
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}`
+	variables := map[string]interface{}{
+		"owner": conf.RepoOwner,
+		"name":  conf.RepoName,
+		"oid":   commitHash,
+	}
+	var graphQLResponse MergeGraphQLResponseGithub
+	graphQLRequest(query, variables, conf, &graphQLResponse)
+	if len(graphQLResponse.Data.Repository.Object.PullRequests.Edges) == 0 {
+		return nil
+	}
+	pullRequest := graphQLResponse.Data.Repository.Object.PullRequests.Edges[0].Node
+	pullRequest.Labels = fetchAllLabelsGithub(conf, pullRequest.Number, pullRequest.Labels)
+	return pullRequest.toPullRequest()
+}
+
+// fetches the remaining pages of the labels connection of a pull request
+func fetchAllLabelsGithub(conf Conf, number int, labels LabelConnectionGithub) LabelConnectionGithub {
+	query := `
+		query($owner: String!, $name: String!, $number: Int!, $after: String) {
+			repository(owner: $owner, name: $name) {
+				pullRequest(number: $number) {
+					labels(first: 100, after: $after) {
+						totalCount
+						pageInfo {
+							hasNextPage
+							endCursor
+						}
+						edges {
+							node {
+								name
+							}
+						}
+					}
+				}
+			}
+		}`
+	pageInfo := labels.PageInfo
+	for page := 1; pageInfo.HasNextPage; page++ {
+		checkNextPage(pageInfo, page, "labels of pull request", number)
+		variables := map[string]interface{}{
+			"owner":  conf.RepoOwner,
+			"name":   conf.RepoName,
+			"number": number,
+			"after":  pageInfo.EndCursor,
+		}
+		var graphQLResponse LabelsGraphQLResponseGithub
+		graphQLRequest(query, variables, conf, &graphQLResponse)
+		nextLabels := graphQLResponse.Data.Repository.PullRequest.Labels
+		labels.Edges = append(labels.Edges, nextLabels.Edges...)
+		pageInfo = nextLabels.PageInfo
+	}
+	checkLabelsComplete(number, len(labels.Edges), labels.TotalCount)
+	return labels
+}
+
+func (pullRequest PullRequestGithub) toPullRequest() *PullRequest {
+	var labels []string
+	for _, label := range pullRequest.Labels.Edges {
+		labels = append(labels, label.Node.Name)
+	}
+	return &PullRequest{
+		Number:          pullRequest.Number,
+		Url:             pullRequest.Url,
+		Title:           pullRequest.Title,
+		TitleHtml:       pullRequest.TitleHtml,
+		Description:     pullRequest.Body,
+		DescriptionHtml: pullRequest.BodyHtml,
+		MergeCommitSha:  pullRequest.MergeCommit.Oid,
+		Author:          pullRequest.Author.Login,
+		SourceBranch:    pullRequest.HeadRefName,
+		TargetBranch:    pullRequest.BaseRefName,
+		Milestone:       pullRequest.Milestone.Title,
+		Labels:          labels,
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+type PRGraphQLResponseGitlab struct {
+	Data struct {
+		Project *struct {
+			MergeRequest *MergeRequestGitlab `json:"mergeRequest"`
+		} `json:"project"`
+	} `json:"data"`
+}
+
+type MergeRequestLabelGitlab struct {
+	Node struct {
+		Title string `json:"title"`
+	} `json:"node"`
+}
+type MergeRequestGitlab struct {
+	Iid             string `json:"iid"`
+	WebUrl          string `json:"webUrl"`
+	Description     string `json:"description"`
+	DescriptionHtml string `json:"descriptionHtml"`
+	Title           string `json:"title"`
+	TitleHtml       string `json:"titleHtml"`
+	MergeCommitSha  string `json:"mergeCommitSha"`
+	SquashCommitSha string `json:"squashCommitSha"`
+	DiffHeadSha     string `json:"diffHeadSha"`
+	SourceBranch    string `json:"sourceBranch"`
+	TargetBranch    string `json:"targetBranch"`
+	Author          struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Milestone struct {
+		Title string `json:"title"`
+	} `json:"milestone"`
+	Labels LabelConnectionGitlab `json:"labels"`
+}
+type LabelConnectionGitlab struct {
+	Count    int                       `json:"count"`
+	PageInfo PageInfo                  `json:"pageInfo"`
+	Edges    []MergeRequestLabelGitlab `json:"edges"`
+}
+
+type LabelsGraphQLResponseGitlab struct {
+	Data struct {
+		Project struct {
+			MergeRequest struct {
+				Labels LabelConnectionGitlab `json:"labels"`
+			} `json:"mergeRequest"`
+		} `json:"project"`
+	} `json:"data"`
+}
+type MergeRequestGitlabEdge struct {
+	Node MergeRequestGitlab `json:"node"`
+}
+
+type MergeGraphQLResponseGitlab struct {
+	Data struct {
+		Project *struct {
+			MergeRequests struct {
+				PageInfo PageInfo                 `json:"pageInfo"`
+				Edges    []MergeRequestGitlabEdge `json:"edges"`
+			} `json:"mergeRequests"`
+		} `json:"project"`
+	} `json:"data"`
+}
+
+// merge request as returned by the REST API for the merge requests of a commit
+type CommitMergeRequestGitlab struct {
+	Iid             int    `json:"iid"`
+	State           string `json:"state"`
+	Sha             string `json:"sha"`
+	MergeCommitSha  string `json:"merge_commit_sha"`
+	SquashCommitSha string `json:"squash_commit_sha"`
+}
+
+// number of merged merge requests to scan if the commit has no associated merge request
+const maxMergeRequestScan = 1000
+
+type gitlabLabelSource struct{}
+
+func init() {
+	registerLabelSource("gitlab", gitlabLabelSource{})
+}
+
+func (gitlabLabelSource) Validate(conf Conf) error {
+	if len(conf.ProjectPath) == 0 {
+		return errors.New("Missing project path argument")
+	}
+	return nil
+}
+
+func (gitlabLabelSource) FetchPullRequest(conf Conf, number int) *PullRequest {
+	query := `
+		query($projectPath: ID!, $iid: String!) {
+			project(fullPath: $projectPath) {
+				mergeRequest(iid: $iid) {
+					iid
+					webUrl
+					title
+					titleHtml
+					description
+					descriptionHtml
+					mergeCommitSha
+					sourceBranch
+					targetBranch
+					author {
+						username
+					}
+					milestone {
+						title
+					}
+					labels(first: 100) {
+						count
+						pageInfo {
+							hasNextPage
+							endCursor
+						}
+						edges {
+							node {
+								title
+							}
+						}
+					}
+				}
+			}
+		}`
+	variables := map[string]interface{}{
+		"projectPath": conf.ProjectPath,
+		"iid":         strconv.Itoa(number),
+	}
+	var graphQLResponse PRGraphQLResponseGitlab
+	graphQLRequest(query, variables, conf, &graphQLResponse)
+	if graphQLResponse.Data.Project == nil {
+		failProjectNotFoundGitlab(conf)
+	}
+	mergeRequest := graphQLResponse.Data.Project.MergeRequest
+	if mergeRequest == nil {
+		return nil
+	}
+	mergeRequest.Labels = fetchAllLabelsGitlab(conf, mergeRequest.Iid, mergeRequest.Labels)
+	return mergeRequest.toPullRequest()
+}
+
+/*
+Resolves the merge request of a commit by
+ 1. GitLab's commit -> merge requests association (REST API), preferring a merge request that was merged or squashed
+    into the commit, then one with the commit as head
+ 2. scanning the merged merge requests, most recently merged first, for a matching merge commit, squash commit or
+    diff head sha
+*/
+func (source gitlabLabelSource) FetchPullRequestForCommit(conf Conf, commitHash string) *PullRequest {
+	iid := findMergeRequestIidForCommitGitlab(conf, commitHash)
+	if iid != 0 {
+		return source.FetchPullRequest(conf, iid)
+	}
+	log.Printf("Commit %s has no associated merge request, scanning merged merge requests...", commitHash)
+	mergeRequest, scanned := scanMergedMergeRequestsGitlab(conf, commitHash)
+	if mergeRequest == nil {
+		log.Warnf("Commit %s is not associated with a merge request of %s and is neither merge commit, squash commit "+
+			"nor head of any of the last %d merged merge requests", commitHash, conf.ProjectPath, scanned)
+		return nil
+	}
+	mergeRequest.Labels = fetchAllLabelsGitlab(conf, mergeRequest.Iid, mergeRequest.Labels)
+	return mergeRequest.toPullRequest()
+}
+
+func findMergeRequestIidForCommitGitlab(conf Conf, commitHash string) int {
+	nextPage := "1"
+	bestIid := 0
+	bestRank := 0
+	for page := 1; len(nextPage) > 0; page++ {
+		if page > maxPages {
+			fail("Failed to fetch all merge requests of commit %s: giving up after %d pages", commitHash, maxPages)
+		}
+		requestUrl := gitlabBaseUrl(conf) + "/api/v4/projects/" + url.PathEscape(conf.ProjectPath) +
+			"/repository/commits/" + url.PathEscape(commitHash) + "/merge_requests?per_page=100&page=" + nextPage
+		status, header, jsonResponse := restRequest(conf, requestUrl)
+		if status == http.StatusNotFound {
+			log.Warnf("Commit %s not found in project %s", commitHash, conf.ProjectPath)
+			return 0
+		}
+		var mergeRequests []CommitMergeRequestGitlab
+		err := json.NewDecoder(strings.NewReader(jsonResponse)).Decode(&mergeRequests)
+		if err != nil {
+			fail("failed to decode gitlab response: %v\n", err)
+		}
+		for _, mergeRequest := range mergeRequests {
+			rank := mergeRequest.rank(commitHash)
+			if rank > bestRank {
+				bestIid = mergeRequest.Iid
+				bestRank = rank
+			}
+		}
+		nextPage = header.Get("X-Next-Page")
+	}
+	return bestIid
+}
+
+func (mergeRequest CommitMergeRequestGitlab) rank(commitHash string) int {
+	rank := 1
+	if mergeRequest.State == "merged" {
+		rank += 1
+	}
+	if sameCommit(mergeRequest.MergeCommitSha, commitHash) || sameCommit(mergeRequest.SquashCommitSha, commitHash) {
+		rank += 4
+	} else if sameCommit(mergeRequest.Sha, commitHash) {
+		rank += 2
+	}
+	return rank
+}
+
+func scanMergedMergeRequestsGitlab(conf Conf, commitHash string) (*MergeRequestGitlab, int) {
+	query := `
+		query($projectPath: ID!, $after: String) {
+			project(fullPath: $projectPath) {
+				mergeRequests(first: 100, after: $after, state: merged, sort: MERGED_AT_DESC) {
+					pageInfo {
+						hasNextPage
+						endCursor
+					}
+					edges {
+						node {
+							iid
+							webUrl
+							title
+							titleHtml
+							description
+							descriptionHtml
+							mergeCommitSha
+							sourceBranch
+							targetBranch
+							author {
+								username
+							}
+							milestone {
+								title
+							}
+							squashCommitSha
+							diffHeadSha
+							labels(first: 100) {
+								count
+								pageInfo {
+									hasNextPage
+									endCursor
+								}
+								edges {
+									node {
+										title
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}`
+	var after interface{}
+	scanned := 0
+	for scanned < maxMergeRequestScan {
+		variables := map[string]interface{}{
+			"projectPath": conf.ProjectPath,
+			"after":       after,
+		}
+		var graphQLResponse MergeGraphQLResponseGitlab
+		graphQLRequest(query, variables, conf, &graphQLResponse)
+		if graphQLResponse.Data.Project == nil {
+			failProjectNotFoundGitlab(conf)
+		}
+
+		mergeRequests := graphQLResponse.Data.Project.MergeRequests
+		scanned += len(mergeRequests.Edges)
+		for _, mr := range mergeRequests.Edges {
+			if sameCommit(mr.Node.MergeCommitSha, commitHash) || sameCommit(mr.Node.SquashCommitSha, commitHash) ||
+				sameCommit(mr.Node.DiffHeadSha, commitHash) {
+				mergeRequest := mr.Node
+				return &mergeRequest, scanned
+			}
+		}
+		if !mergeRequests.PageInfo.HasNextPage || len(mergeRequests.PageInfo.EndCursor) == 0 {
+			break
+		}
+		after = mergeRequests.PageInfo.EndCursor
+	}
+	return nil, scanned
+}
+
+// fetches the remaining pages of the labels connection of a merge request
+func fetchAllLabelsGitlab(conf Conf, iid string, labels LabelConnectionGitlab) LabelConnectionGitlab {
+	query := `
+		query($projectPath: ID!, $iid: String!, $after: String) {
+			project(fullPath: $projectPath) {
+				mergeRequest(iid: $iid) {
+					labels(first: 100, after: $after) {
+						count
+						pageInfo {
+							hasNextPage
+							endCursor
+						}
+						edges {
+							node {
+								title
+							}
+						}
+					}
+				}
+			}
+		}`
+	number, _ := strconv.Atoi(iid)
+	pageInfo := labels.PageInfo
+	for page := 1; pageInfo.HasNextPage; page++ {
+		checkNextPage(pageInfo, page, "labels of merge request", number)
+		variables := map[string]interface{}{
+			"projectPath": conf.ProjectPath,
+			"iid":         iid,
+			"after":       pageInfo.EndCursor,
+		}
+		var graphQLResponse LabelsGraphQLResponseGitlab
+		graphQLRequest(query, variables, conf, &graphQLResponse)
+		nextLabels := graphQLResponse.Data.Project.MergeRequest.Labels
+		labels.Edges = append(labels.Edges, nextLabels.Edges...)
+		pageInfo = nextLabels.PageInfo
+	}
+	checkLabelsComplete(number, len(labels.Edges), labels.Count)
+	return labels
+}
+
+func failProjectNotFoundGitlab(conf Conf) {
+	fail("Project %v not found or not accessible with the given auth token", conf.ProjectPath)
+}
+
+func (mergeRequest MergeRequestGitlab) toPullRequest() *PullRequest {
+	var labels []string
+	for _, label := range mergeRequest.Labels.Edges {
+		labels = append(labels, label.Node.Title)
+	}
+	number, _ := strconv.Atoi(mergeRequest.Iid)
+	return &PullRequest{
+		Number:          number,
+		Url:             mergeRequest.WebUrl,
+		Title:           mergeRequest.Title,
+		TitleHtml:       mergeRequest.TitleHtml,
+		Description:     mergeRequest.Description,
+		DescriptionHtml: mergeRequest.DescriptionHtml,
+		MergeCommitSha:  mergeRequest.MergeCommitSha,
+		Author:          mergeRequest.Author.Username,
+		SourceBranch:    mergeRequest.SourceBranch,
+		TargetBranch:    mergeRequest.TargetBranch,
+		Milestone:       mergeRequest.Milestone.Title,
+		Labels:          labels,
+	}
+}
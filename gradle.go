@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// the build types Android projects have without declaring them
+var defaultGradleBuildTypes = []string{"debug", "release"}
+
+// pseudo dimension name for the build types
+const gradleBuildTypes = "buildTypes"
+
+// directories not searched for build files
+var skippedGradleDirs = map[string]bool{".git": true, ".gradle": true, ".idea": true, "build": true, "node_modules": true}
+
+// flavors and build types declared in the Gradle build files of a project
+type GradleProject struct {
+	Files []string
+	// flavor dimensions in declaration order
+	Dimensions []string
+	// product flavor -> dimension, empty if the flavor has none
+	Flavors    map[string]string
+	BuildTypes map[string]bool
+}
+
+/*
+checks that every configured flavor is a product flavor of the matching Gradle flavor dimension or a build type,
+according to gradle_validation: "off", "warn" or "fail".
+
+A configured dimension matches the Gradle flavor dimension of the same name, otherwise the Gradle flavor dimension
+or the build types containing most of its flavors.
+*/
+func validateGradleFlavors(conf Conf, flavorDimensions map[int]FlavorDimension) {
+	switch conf.GradleValidation {
+	case "", "off":
+		return
+	case "warn", "fail":
+	default:
+		fail("Invalid gradle validation: %v. Allowed are: off, warn, fail", conf.GradleValidation)
+	}
+	projectPath := conf.GradleProjectPath
+	if len(projectPath) == 0 {
+		projectPath = "."
+	}
+	project, err := parseGradleProject(projectPath)
+	if err != nil {
+		fail("Failed to read Gradle build files: %v", err)
+	}
+
+	var problems []string
+	if len(project.Files) == 0 {
+		problems = append(problems, fmt.Sprintf("No build.gradle or build.gradle.kts found in %v", projectPath))
+	} else {
+		for _, flavorDimension := range sortedFlavorDimensions(flavorDimensions) {
+			problems = append(problems, project.check(flavorDimension)...)
+		}
+	}
+	if len(problems) == 0 {
+		log.Printf("Flavors validated against %v", strings.Join(project.Files, ", "))
+		return
+	}
+	if conf.GradleValidation == "fail" {
+		fail("Gradle validation failed:\n%v", strings.Join(problems, "\n"))
+	}
+	for _, problem := range problems {
+		log.Warnf("%v", problem)
+	}
+}
+
+// the flavors of a Gradle dimension, or the build types for gradleBuildTypes
+func (project GradleProject) flavorsOf(dimension string) []string {
+	var names []string
+	if dimension == gradleBuildTypes {
+		for name := range project.BuildTypes {
+			names = append(names, name)
+		}
+	} else {
+		for name, flavorDimension := range project.Flavors {
+			if flavorDimension == dimension {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (project GradleProject) check(flavorDimension FlavorDimension) []string {
+	var flavors []string
+	for _, label := range flavorDimension.Labels {
+		flavors = appendUnique(flavors, flavorDimension.Flavors[label])
+	}
+
+	candidates := append(append([]string{}, project.Dimensions...), gradleBuildTypes)
+	target := ""
+	bestCount := 0
+	for _, candidate := range candidates {
+		if len(flavorDimension.Name) > 0 && flavorDimension.Name == candidate {
+			target = candidate
+			break
+		}
+		count := 0
+		for _, flavor := range flavors {
+			if containsString(project.flavorsOf(candidate), flavor) {
+				count++
+			}
+		}
+		if count > bestCount {
+			target = candidate
+			bestCount = count
+		}
+	}
+
+	var problems []string
+	for _, flavor := range flavors {
+		if len(target) > 0 && containsString(project.flavorsOf(target), flavor) {
+			continue
+		}
+		problem := ""
+		if len(target) == 0 {
+			problem = fmt.Sprintf("Flavor %v of dimension %v is neither a product flavor nor a build type", flavor, flavorDimension)
+		} else if target == gradleBuildTypes {
+			problem = fmt.Sprintf("Flavor %v of dimension %v is not a build type", flavor, flavorDimension)
+		} else {
+			problem = fmt.Sprintf("Flavor %v of dimension %v is not a product flavor of the Gradle dimension %v", flavor, flavorDimension, target)
+		}
+		if dimension, ok := project.Flavors[flavor]; ok && len(dimension) > 0 {
+			problem += fmt.Sprintf(", it belongs to the Gradle dimension %v", dimension)
+		} else if ok {
+			problem += ", it has no dimension in Gradle"
+		} else if suggestion := nearestName(flavor, project.flavorsOf(target)); len(suggestion) > 0 {
+			problem += fmt.Sprintf(". Did you mean %v?", suggestion)
+		} else if suggestion := nearestName(flavor, project.allNames()); len(suggestion) > 0 {
+			problem += fmt.Sprintf(". Did you mean %v?", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+func (project GradleProject) allNames() []string {
+	var names []string
+	for name := range project.Flavors {
+		names = append(names, name)
+	}
+	for name := range project.BuildTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseGradleProject(projectPath string) (GradleProject, error) {
+	project := GradleProject{Flavors: make(map[string]string), BuildTypes: make(map[string]bool)}
+	for _, buildType := range defaultGradleBuildTypes {
+		project.BuildTypes[buildType] = true
+	}
+	err := filepath.Walk(projectPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != projectPath && skippedGradleDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() != "build.gradle" && info.Name() != "build.gradle.kts" {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		project.Files = append(project.Files, path)
+		project.parse(stripGradleComments(string(content)))
+		return nil
+	})
+	return project, err
+}
+
+var gradleFlavorDimensionsRegex = regexp.MustCompile(`\bflavorDimensions\b[^\n]*`)
+var gradleStringRegex = regexp.MustCompile(`["']([^"'\n]+)["']`)
+var gradleProductFlavorsRegex = regexp.MustCompile(`\bproductFlavors\s*\{`)
+var gradleBuildTypesRegex = regexp.MustCompile(`\bbuildTypes\s*\{`)
+var gradleDimensionRegex = regexp.MustCompile(`\b(?:setDimension|dimension)\s*(?:=\s*|\(\s*)?["']([^"'\n]+)["']`)
+
+// the name of an entry in a productFlavors or buildTypes block: "full {", `create("full") {`, `getByName("release") {`
+var gradleEntryRegex = regexp.MustCompile(`^(?:(?:create|register|maybeCreate|getByName|named)\s*\(\s*["']([^"']+)["']\s*\)|["']?([A-Za-z_]\w*)["']?)$`)
+
+// entries of productFlavors and buildTypes blocks that configure all elements
+var gradleContainerMethods = map[string]bool{"all": true, "configureEach": true, "whenObjectAdded": true}
+
+// collects flavor dimensions, product flavors and build types of a build file, Groovy or Kotlin DSL
+func (project *GradleProject) parse(content string) {
+	for _, statement := range gradleFlavorDimensionsRegex.FindAllString(content, -1) {
+		for _, match := range gradleStringRegex.FindAllStringSubmatch(statement, -1) {
+			project.Dimensions = appendUnique(project.Dimensions, match[1])
+		}
+	}
+	var flavorsWithoutDimension []string
+	for _, block := range gradleBlocks(content, gradleProductFlavorsRegex) {
+		for name, body := range gradleBlockEntries(block) {
+			match := gradleDimensionRegex.FindStringSubmatch(body)
+			if match == nil {
+				flavorsWithoutDimension = append(flavorsWithoutDimension, name)
+				continue
+			}
+			project.Flavors[name] = match[1]
+			project.Dimensions = appendUnique(project.Dimensions, match[1])
+		}
+	}
+	for _, name := range flavorsWithoutDimension {
+		// with a single dimension, Gradle assigns it to flavors without one
+		if len(project.Dimensions) == 1 {
+			project.Flavors[name] = project.Dimensions[0]
+		} else if _, ok := project.Flavors[name]; !ok {
+			project.Flavors[name] = ""
+		}
+	}
+	for _, block := range gradleBlocks(content, gradleBuildTypesRegex) {
+		for name := range gradleBlockEntries(block) {
+			project.BuildTypes[name] = true
+		}
+	}
+}
+
+// the contents of the blocks starting with the given regex, which has to end with the opening brace
+func gradleBlocks(content string, start *regexp.Regexp) []string {
+	var blocks []string
+	for _, match := range start.FindAllStringIndex(content, -1) {
+		end := matchingBrace(content, match[1]-1)
+		blocks = append(blocks, content[match[1]:end])
+	}
+	return blocks
+}
+
+// the named sub blocks of a block with their bodies
+func gradleBlockEntries(block string) map[string]string {
+	entries := make(map[string]string)
+	statementStart := 0
+	for i := 0; i < len(block); i++ {
+		switch block[i] {
+		case '"', '\'':
+			i = skipString(block, i)
+		case ';':
+			statementStart = i + 1
+		case '{':
+			end := matchingBrace(block, i)
+			if name := gradleEntryName(block[statementStart:i]); len(name) > 0 {
+				entries[name] = block[i+1 : end]
+			}
+			i = end
+			statementStart = end + 1
+		}
+	}
+	return entries
+}
+
+// the name from the last line of the statement before a block
+func gradleEntryName(header string) string {
+	lines := strings.Split(strings.TrimSpace(header), "\n")
+	match := gradleEntryRegex.FindStringSubmatch(strings.TrimSpace(lines[len(lines)-1]))
+	if match == nil {
+		return ""
+	}
+	name := match[1] + match[2]
+	if gradleContainerMethods[name] {
+		return ""
+	}
+	return name
+}
+
+// the index of the brace closing the one at start, or the end of the content if it is not closed
+func matchingBrace(content string, start int) int {
+	depth := 0
+	for i := start; i < len(content); i++ {
+		switch content[i] {
+		case '"', '\'':
+			i = skipString(content, i)
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(content)
+}
+
+// the index of the quote closing the string starting at start
+func skipString(content string, start int) int {
+	for i := start + 1; i < len(content); i++ {
+		if content[i] == '\\' {
+			i++
+		} else if content[i] == content[start] || content[i] == '\n' {
+			return i
+		}
+	}
+	return len(content)
+}
+
+// removes // and /* */ comments outside of strings
+func stripGradleComments(content string) string {
+	var stripped strings.Builder
+	for i := 0; i < len(content); i++ {
+		switch {
+		case content[i] == '"' || content[i] == '\'':
+			end := skipString(content, i)
+			if end >= len(content) {
+				end = len(content) - 1
+			}
+			stripped.WriteString(content[i : end+1])
+			i = end
+		case strings.HasPrefix(content[i:], "//"):
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			stripped.WriteByte('\n')
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				return stripped.String()
+			}
+			i += end + 3
+		default:
+			stripped.WriteByte(content[i])
+		}
+	}
+	return stripped.String()
+}
+
+// the most similar name, if it is similar enough to be a typo
+func nearestName(name string, names []string) string {
+	nearest := ""
+	nearestDistance := len(name)/2 + 1
+	for _, candidate := range names {
+		distance := levenshtein(strings.ToLower(name), strings.ToLower(candidate))
+		if distance < nearestDistance {
+			nearest = candidate
+			nearestDistance = distance
+		}
+	}
+	return nearest
+}
+
+func levenshtein(a string, b string) int {
+	runesA, runesB := []rune(a), []rune(b)
+	previous := make([]int, len(runesB)+1)
+	current := make([]int, len(runesB)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(runesA); i++ {
+		current[0] = i
+		for j := 1; j <= len(runesB); j++ {
+			cost := 1
+			if runesA[i-1] == runesB[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(runesB)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func containsString(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGradleProjectGroovy(t *testing.T) {
+	project, err := parseGradleProject(filepath.Join("testdata", "gradle", "groovy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(project.Files) != 2 {
+		t.Errorf("got files %v, want the root and app build files", project.Files)
+	}
+	if want := []string{"tier", "color"}; !reflect.DeepEqual(project.Dimensions, want) {
+		t.Errorf("got dimensions %v, want %v", project.Dimensions, want)
+	}
+	wantFlavors := map[string]string{"full": "tier", "demo": "tier", "orange": "color", "blue": "color"}
+	if !reflect.DeepEqual(project.Flavors, wantFlavors) {
+		t.Errorf("got flavors %v, want %v", project.Flavors, wantFlavors)
+	}
+	wantBuildTypes := map[string]bool{"debug": true, "release": true, "staging": true}
+	if !reflect.DeepEqual(project.BuildTypes, wantBuildTypes) {
+		t.Errorf("got build types %v, want %v", project.BuildTypes, wantBuildTypes)
+	}
+}
+
+func TestParseGradleProjectKotlin(t *testing.T) {
+	project, err := parseGradleProject(filepath.Join("testdata", "gradle", "kts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"tier"}; !reflect.DeepEqual(project.Dimensions, want) {
+		t.Errorf("got dimensions %v, want %v", project.Dimensions, want)
+	}
+	wantFlavors := map[string]string{"full": "tier", "demo": "tier"}
+	if !reflect.DeepEqual(project.Flavors, wantFlavors) {
+		t.Errorf("got flavors %v, want %v", project.Flavors, wantFlavors)
+	}
+	wantBuildTypes := map[string]bool{"debug": true, "release": true, "benchmark": true}
+	if !reflect.DeepEqual(project.BuildTypes, wantBuildTypes) {
+		t.Errorf("got build types %v, want %v", project.BuildTypes, wantBuildTypes)
+	}
+}
+
+func TestParseGradleProjectWithoutBuildFiles(t *testing.T) {
+	project, err := parseGradleProject(filepath.Join("testdata", "gradle", "groovy", "app", "missing"))
+	if err == nil {
+		t.Errorf("got project %v, want an error for a missing directory", project)
+	}
+}
+
+func TestFlavorsWithoutDimension(t *testing.T) {
+	var project GradleProject
+	project.Flavors = make(map[string]string)
+	project.parse(`flavorDimensions "tier"
+productFlavors {
+    full {}
+}`)
+	if project.Flavors["full"] != "tier" {
+		t.Errorf("got dimension %q for full, want the only dimension tier", project.Flavors["full"])
+	}
+}
+
+func TestStripGradleComments(t *testing.T) {
+	tests := map[string]string{
+		"full { } // comment":                 "full { } \n",
+		"a /* block\n comment */b":            "a b",
+		`url "http://example.com" // comment`: `url "http://example.com" ` + "\n",
+		`s '/* not a comment */'`:             `s '/* not a comment */'`,
+		"a /* unterminated":                   "a ",
+		`"unterminated`:                       `"unterminated`,
+	}
+	for content, want := range tests {
+		if got := stripGradleComments(content); got != want {
+			t.Errorf("stripGradleComments(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func TestGradleBlockEntries(t *testing.T) {
+	block := `
+        full { dimension "tier" }
+        create("demo") { dimension = "tier"; suffix = "}" }
+        getByName("release") {
+            nested { }
+        }
+        all { x() }
+        configureEach { y() }
+    `
+	entries := gradleBlockEntries(block)
+	if len(entries) != 3 || !strings.Contains(entries["demo"], `suffix = "}"`) || !strings.Contains(entries["release"], "nested") {
+		t.Errorf("got entries %q, want full, demo and release with their bodies", entries)
+	}
+}
+
+func TestMatchingBrace(t *testing.T) {
+	content := `{ a { "}" } '{' }tail`
+	if got := matchingBrace(content, 0); content[got+1:] != "tail" {
+		t.Errorf("matchingBrace = %d, want the brace before tail", got)
+	}
+	if got := matchingBrace("{ {", 0); got != 3 {
+		t.Errorf("matchingBrace of an unclosed block = %d, want the end of the content", got)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"full", "full", 0},
+		{"ful", "full", 1},
+		{"demo", "dmeo", 2},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+		{"ä", "a", 1},
+	}
+	for _, test := range tests {
+		if got := levenshtein(test.a, test.b); got != test.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestNearestName(t *testing.T) {
+	names := []string{"full", "demo", "release"}
+	tests := map[string]string{
+		"ful":     "full",
+		"Demo":    "demo",
+		"relase":  "release",
+		"staging": "",
+	}
+	for name, want := range tests {
+		if got := nearestName(name, names); got != want {
+			t.Errorf("nearestName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGradleProjectCheck(t *testing.T) {
+	project, err := parseGradleProject(filepath.Join("testdata", "gradle", "groovy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dimension := func(name string, flavors ...string) FlavorDimension {
+		flavorDimension := FlavorDimension{Index: 1, Name: name, Flavors: make(map[string]string)}
+		for _, flavor := range flavors {
+			flavorDimension.Flavors[flavor] = flavor
+			flavorDimension.Labels = append(flavorDimension.Labels, flavor)
+		}
+		return flavorDimension
+	}
+	tests := []struct {
+		dimension FlavorDimension
+		want      []string
+	}{
+		{dimension("tier", "full", "demo"), nil},
+		{dimension("", "orange", "blue"), nil},
+		{dimension("", "debug", "staging"), nil},
+		{dimension("tier", "full", "dmeo"), []string{"Flavor dmeo of dimension tier is not a product flavor of the Gradle dimension tier. Did you mean demo?"}},
+		{dimension("tier", "full", "blue"), []string{"Flavor blue of dimension tier is not a product flavor of the Gradle dimension tier, it belongs to the Gradle dimension color"}},
+		{dimension("", "relase"), []string{"Flavor relase of dimension 1 is neither a product flavor nor a build type. Did you mean release?"}},
+	}
+	for _, test := range tests {
+		if got := project.check(test.dimension); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("check(%v %v) = %q, want %q", test.dimension, test.dimension.Labels, got, test.want)
+		}
+	}
+}
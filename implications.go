@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/tools"
+)
+
+// an implication rule adds labels and environment variables if a label is set, see ConfigImplication
+type ImplicationRule struct {
+	Label   string
+	Matcher *LabelMatcher
+	Implies []string
+	Env     map[string]string
+	Source  string
+}
+
+// parses the label_implications input "release-candidate=full+prod,hotfix=prod" into rules as in the config file
+func parseLabelImplications(conf Conf) []ConfigImplication {
+	var implications []ConfigImplication
+	for _, spec := range strings.Split(conf.LabelImplications, ",") {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			continue
+		}
+		label, implied := splitLabelSpec(spec)
+		if label == implied {
+			fail("label implication %v does not imply any label, expected {label}={label}+{label}...", spec)
+		}
+		implication := ConfigImplication{Label: strings.TrimSpace(label), Source: "input: " + spec}
+		for _, impliedLabel := range strings.Split(implied, "+") {
+			if impliedLabel = strings.TrimSpace(impliedLabel); len(impliedLabel) > 0 {
+				implication.Implies = append(implication.Implies, impliedLabel)
+			}
+		}
+		implications = append(implications, implication)
+	}
+	return implications
+}
+
+// the rules of the config, failing if they imply each other in a cycle
+func (config Config) getImplicationRules(conf Conf) []ImplicationRule {
+	var rules []ImplicationRule
+	for _, implication := range config.Implications {
+		rule := ImplicationRule{Label: implication.Label, Implies: implication.Implies, Env: implication.Env, Source: implication.Source}
+		if isLabelPattern(implication.Label) {
+			matcher := newConfigLabelMatcher(implication.Label, implication.Label, conf)
+			rule.Matcher = &matcher
+		}
+		rules = append(rules, rule)
+	}
+	if cycle := findImplicationCycle(rules, conf.IgnoreLabelCase); cycle != nil {
+		var path []string
+		for _, index := range cycle {
+			path = append(path, fmt.Sprintf("%v (%v)", rules[index].Label, rules[index].Source))
+		}
+		fail("Label implications form a cycle: %v", strings.Join(path, " -> "))
+	}
+	return rules
+}
+
+// the rule indexes of the first cycle, starting and ending with the same rule, nil if there is none
+func findImplicationCycle(rules []ImplicationRule, ignoreCase bool) []int {
+	for i := range rules {
+		if cycle := implicationCycle(rules, []int{i}, ignoreCase); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func (rule ImplicationRule) matches(label string, ignoreCase bool) bool {
+	if rule.Matcher != nil {
+		return rule.Matcher.Regex.MatchString(label)
+	}
+	return label == rule.Label || (ignoreCase && strings.EqualFold(label, rule.Label))
+}
+
+// the rule indexes from the start of path back to a rule already on it, nil if there is no cycle
+func implicationCycle(rules []ImplicationRule, path []int, ignoreCase bool) []int {
+	for _, implied := range rules[path[len(path)-1]].Implies {
+		for next, rule := range rules {
+			if !rule.matches(implied, ignoreCase) {
+				continue
+			}
+			if next == path[0] {
+				return append(path, next)
+			}
+			onPath := false
+			for _, index := range path {
+				onPath = onPath || index == next
+			}
+			if onPath {
+				// a cycle not involving the start, reported when starting from one of its rules
+				continue
+			}
+			if cycle := implicationCycle(rules, append(append([]int{}, path...), next), ignoreCase); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+/*
+adds the labels implied by the given ones, transitively, and returns them with the environment variables of the
+rules that applied. Variables of later rules win.
+*/
+func applyImplications(rules []ImplicationRule, labels []string, ignoreCase bool) ([]string, map[string]string) {
+	env := make(map[string]string)
+	result := append([]string{}, labels...)
+	for i := 0; i < len(result); i++ {
+		for _, rule := range rules {
+			if !rule.matches(result[i], ignoreCase) {
+				continue
+			}
+			for _, implied := range rule.Implies {
+				if containsString(result, implied) {
+					continue
+				}
+				result = append(result, implied)
+				fmt.Printf("Label %s implied by %s (%s)\n", implied, result[i], rule.Source)
+			}
+			for key, value := range rule.Env {
+				env[key] = value
+			}
+		}
+	}
+	return result, env
+}
+
+func exportImplicationEnv(env map[string]string) {
+	var keys []string
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s = %s\n", key, env[key])
+		if err := tools.ExportEnvironmentWithEnvman(key, env[key]); err != nil {
+			fmt.Printf("Failed to export environment variable: %s=%s: %v\n", key, env[key], err)
+		}
+	}
+}
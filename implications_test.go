@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rules from "{label}={implied}+{implied}..." specs, with a matcher for label patterns
+func testImplicationRules(t *testing.T, specs ...string) []ImplicationRule {
+	var rules []ImplicationRule
+	for _, spec := range specs {
+		label, implied := splitLabelSpec(spec)
+		rule := ImplicationRule{Label: label, Implies: strings.Split(implied, "+"), Source: spec}
+		if isLabelPattern(label) {
+			matcher, err := newLabelMatcher(label, label, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule.Matcher = &matcher
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func TestFindImplicationCycle(t *testing.T) {
+	tests := []struct {
+		name       string
+		specs      []string
+		ignoreCase bool
+		want       []int
+	}{
+		{"no cycle", []string{"rc=full+prod", "full=paid", "hotfix=prod"}, false, nil},
+		{"shared implied label", []string{"a=c", "b=c", "c=d"}, false, nil},
+		{"direct cycle", []string{"a=b", "b=a"}, false, []int{0, 1, 0}},
+		{"self implication", []string{"a=b+a"}, false, []int{0, 0}},
+		{"indirect cycle", []string{"a=b", "b=c", "c=a"}, false, []int{0, 1, 2, 0}},
+		{"cycle not through the first rule", []string{"x=a", "a=b", "b=a"}, false, []int{1, 2, 1}},
+		{"pattern implying a label it matches", []string{"tier/*=tier/full"}, false, []int{0, 0}},
+		{"cycle through a pattern", []string{"rc-*=full", "full=rc-2"}, false, []int{0, 1, 0}},
+		{"cycle ignoring case", []string{"a=B", "b=a"}, true, []int{0, 1, 0}},
+		{"no cycle with case", []string{"a=B", "b=a"}, false, nil},
+	}
+	for _, test := range tests {
+		got := findImplicationCycle(testImplicationRules(t, test.specs...), test.ignoreCase)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got cycle %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestApplyImplications(t *testing.T) {
+	rules := testImplicationRules(t, "rc=full+prod", "full=paid", "dist_*=prod", "prod=paid")
+	rules[0].Env = map[string]string{"TRACK": "beta", "NOTES": "rc"}
+	rules[3].Env = map[string]string{"TRACK": "production"}
+
+	labels, env := applyImplications(rules, []string{"rc", "blue"}, false)
+	if want := []string{"rc", "blue", "full", "prod", "paid"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("got labels %v, want the transitively implied ones once each %v", labels, want)
+	}
+	if want := map[string]string{"TRACK": "production", "NOTES": "rc"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("got env %v, want %v with later rules winning", env, want)
+	}
+
+	labels, _ = applyImplications(rules, []string{"dist_store"}, false)
+	if want := []string{"dist_store", "prod", "paid"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("got labels %v for a pattern rule, want %v", labels, want)
+	}
+
+	labels, _ = applyImplications(rules, []string{"RC"}, false)
+	if want := []string{"RC"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("got labels %v, want none implied by a label in other case", labels)
+	}
+	labels, _ = applyImplications(rules, []string{"RC"}, true)
+	if want := []string{"RC", "full", "prod", "paid"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("got labels %v with ignore_label_case, want %v", labels, want)
+	}
+}
+
+func TestParseLabelImplications(t *testing.T) {
+	implications := parseLabelImplications(Conf{LabelImplications: "release-candidate=full+prod, hotfix = prod ,"})
+	want := []ConfigImplication{
+		{Label: "release-candidate", Implies: []string{"full", "prod"}, Source: "input: release-candidate=full+prod"},
+		{Label: "hotfix", Implies: []string{"prod"}, Source: "input: hotfix = prod"},
+	}
+	if !reflect.DeepEqual(implications, want) {
+		t.Errorf("got %+v, want %+v", implications, want)
+	}
+}
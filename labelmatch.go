@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+/*
+A LabelMatcher selects a flavor by a label pattern instead of the exact label:
+  - a glob, containing * or ?: "flavor/*"
+  - a regex between slashes, optionally followed by the flag i for case-insensitive matching: "/flavor[:/] *(.*)/i"
+
+Patterns have to match the whole label. If the matcher belongs to a flavor, a match selects that flavor. Otherwise,
+the text matched by the first * or capture group names the flavor, which has to be declared in the dimension.
+*/
+type LabelMatcher struct {
+	Regex *regexp.Regexp
+	// the declared label selected on a match, empty if the flavor is named by the capture
+	Label string
+	Spec  string
+}
+
+var labelRegexSpec = regexp.MustCompile(`^/(.*)/([a-z]*)$`)
+
+func isLabelPattern(label string) bool {
+	return labelRegexSpec.MatchString(label) || strings.ContainsAny(label, "*?")
+}
+
+// splits "{label}={flavor}" into label and flavor name. A regex label may contain "=" itself.
+func splitLabelSpec(spec string) (string, string) {
+	searchFrom := 0
+	if strings.HasPrefix(spec, "/") {
+		searchFrom = strings.LastIndex(spec, "/") + 1
+	}
+	equalsPos := strings.Index(spec[searchFrom:], "=")
+	if equalsPos < 0 {
+		return spec, spec
+	}
+	return spec[:searchFrom+equalsPos], spec[searchFrom+equalsPos+1:]
+}
+
+// label is the declared label to select on a match, empty if the capture names the flavor
+func newLabelMatcher(spec string, label string, ignoreCase bool) (LabelMatcher, error) {
+	expression := ""
+	if match := labelRegexSpec.FindStringSubmatch(spec); match != nil {
+		for _, flag := range match[2] {
+			if flag != 'i' {
+				return LabelMatcher{}, fmt.Errorf("invalid flag %c of label pattern %v, only i is supported", flag, spec)
+			}
+			ignoreCase = true
+		}
+		expression = match[1]
+	} else {
+		for _, r := range spec {
+			switch r {
+			case '*':
+				expression += "(.*)"
+			case '?':
+				expression += "."
+			default:
+				expression += regexp.QuoteMeta(string(r))
+			}
+		}
+	}
+	expression = "^(?:" + expression + ")$"
+	if ignoreCase {
+		expression = "(?i)" + expression
+	}
+	regex, err := regexp.Compile(expression)
+	if err != nil {
+		return LabelMatcher{}, fmt.Errorf("invalid label pattern %v: %v", spec, err)
+	}
+	if len(label) == 0 && regex.NumSubexp() == 0 {
+		return LabelMatcher{}, fmt.Errorf("label pattern %v has no * or capture group naming the flavor", spec)
+	}
+	return LabelMatcher{Regex: regex, Label: label, Spec: spec}, nil
+}
+
+// the declared labels of the dimension a pull request label selects
+func (flavorDimension FlavorDimension) matchingLabels(labelName string, ignoreCase bool) []string {
+	var labels []string
+	for _, label := range flavorDimension.Labels {
+		if isLabelPattern(label) {
+			continue
+		}
+		if label == labelName || (ignoreCase && strings.EqualFold(label, labelName)) {
+			labels = append(labels, label)
+		}
+	}
+	for _, matcher := range flavorDimension.Matchers {
+		match := matcher.Regex.FindStringSubmatch(labelName)
+		if match == nil {
+			continue
+		}
+		if len(matcher.Label) > 0 {
+			labels = appendUnique(labels, matcher.Label)
+			continue
+		}
+		label := flavorDimension.labelOfFlavor(match[1])
+		if len(label) == 0 {
+			log.Warnf("Label %v matches %v, but %v is not a flavor of dimension %v", labelName, matcher.Spec, match[1], flavorDimension)
+			continue
+		}
+		labels = appendUnique(labels, label)
+	}
+	return labels
+}
+
+// the first declared label of a flavor, matching the flavor name case-insensitively if there is no exact match
+func (flavorDimension FlavorDimension) labelOfFlavor(flavor string) string {
+	for _, label := range flavorDimension.Labels {
+		if flavorDimension.Flavors[label] == flavor {
+			return label
+		}
+	}
+	for _, label := range flavorDimension.Labels {
+		if strings.EqualFold(flavorDimension.Flavors[label], flavor) {
+			return label
+		}
+	}
+	return ""
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// provider independent model of a pull request / merge request
+type PullRequest struct {
+	Number          int
+	Url             string
+	Title           string
+	TitleHtml       string
+	Description     string
+	DescriptionHtml string
+	MergeCommitSha  string
+	Author          string
+	SourceBranch    string
+	TargetBranch    string
+	Milestone       string
+	Labels          []string
+}
+
+/*
+A LabelSource resolves pull requests of one git provider. Adding a provider means implementing this interface and
+registering it with registerLabelSource in an init function of the adapter.
+
+The fetch functions return nil if no pull request can be found. Request failures end the step via fail().
+*/
+type LabelSource interface {
+	// checks the provider specific inputs
+	Validate(conf Conf) error
+	FetchPullRequest(conf Conf, number int) *PullRequest
+	FetchPullRequestForCommit(conf Conf, commitHash string) *PullRequest
+}
+
+var labelSources = make(map[string]LabelSource)
+
+func registerLabelSource(provider string, source LabelSource) {
+	labelSources[provider] = source
+}
+
+func labelSourceProviders() []string {
+	providers := make([]string, 0, len(labelSources))
+	for provider := range labelSources {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	return providers
+}
+
+/*
+marks the flavors matching the pull request labels as selected and returns the set of all labels. Labels match
+exactly, or case-insensitively with ignore_label_case, or by the label patterns of a dimension. A label starting with
+the negation_prefix negates the flavors the rest of it matches, see selectedFlavors. A select-all label of a
+dimension, or the global one, selects all flavors of the dimension. The global one does not apply to single-select
+dimensions.
+*/
+func selectFlavors(conf Conf, pullRequest PullRequest, flavorDimensions map[int]FlavorDimension) map[string]bool {
+	if len(pullRequest.Labels) == 0 {
+		log.Warnf("No labels found, applying defaults...")
+		return nil
+	}
+	var labels = make(map[string]bool)
+	for _, labelName := range pullRequest.Labels {
+		for _, flavorDimension := range sortedFlavorDimensions(flavorDimensions) {
+			for _, label := range flavorDimension.matchingLabels(labelName, conf.IgnoreLabelCase) {
+				flavorDimension.SelectedFlavors[label] = true
+				if label == labelName {
+					fmt.Printf("Found label for flavor %s\n", flavorDimension.Flavors[label])
+				} else {
+					fmt.Printf("Found label %s for flavor %s\n", labelName, flavorDimension.Flavors[label])
+				}
+			}
+			if negatedLabel, ok := trimNegationPrefix(conf, labelName); ok {
+				for _, label := range flavorDimension.matchingLabels(negatedLabel, conf.IgnoreLabelCase) {
+					flavorDimension.NegatedFlavors[label] = true
+					fmt.Printf("Found label %s negating flavor %s\n", labelName, flavorDimension.Flavors[label])
+				}
+			}
+			isGlobal := len(conf.SelectAllLabel) > 0 && sameLabel(conf, labelName, conf.SelectAllLabel) &&
+				flavorDimension.Select != selectSingle
+			isSelectAll := false
+			for _, selectAllLabel := range flavorDimension.SelectAllLabels {
+				isSelectAll = isSelectAll || sameLabel(conf, labelName, selectAllLabel)
+			}
+			if isGlobal || isSelectAll {
+				for _, label := range flavorDimension.Labels {
+					flavorDimension.SelectedFlavors[label] = true
+				}
+				fmt.Printf("Found label %s selecting all flavors of dimension %v\n", labelName, flavorDimension)
+			}
+		}
+		labels[labelName] = true
+	}
+	return labels
+}
+
+// the label without the negation_prefix, if it starts with it
+func trimNegationPrefix(conf Conf, labelName string) (string, bool) {
+	prefix := conf.NegationPrefix
+	if len(prefix) == 0 || len(labelName) <= len(prefix) {
+		return "", false
+	}
+	if sameLabel(conf, labelName[:len(prefix)], prefix) {
+		return labelName[len(prefix):], true
+	}
+	return "", false
+}
+
+// compares two labels, ignoring case with ignore_label_case
+func sameLabel(conf Conf, label string, other string) bool {
+	return label == other || (conf.IgnoreLabelCase && strings.EqualFold(label, other))
+}
+
+// compares two commit hashes, either of which may be abbreviated
+func sameCommit(hash string, other string) bool {
+	if len(hash) < 7 || len(other) < 7 {
+		return false
+	}
+	hash = strings.ToLower(hash)
+	other = strings.ToLower(other)
+	return strings.HasPrefix(hash, other) || strings.HasPrefix(other, hash)
+}
+
+func maybeExportDescription(conf Conf, pullRequest PullRequest) {
+	if len(conf.ExportDescription) == 0 {
+		return
+	}
+	description := ""
+	if len(pullRequest.Title) > 0 || len(pullRequest.Description) > 0 {
+		description = pullRequest.Title + "\n\n" + pullRequest.Description
+	}
+	html := ""
+	if len(pullRequest.TitleHtml) > 0 || len(pullRequest.DescriptionHtml) > 0 {
+		html = pullRequest.TitleHtml + "<br><br>" + pullRequest.DescriptionHtml
+	}
+
+	ext := filepath.Ext(conf.ExportDescription)
+	if len(ext) == 0 || strings.ToLower(ext) == ".txt" {
+		if len(description) == 0 {
+			log.Warnf("Text description not available, but export was requested")
+		} else {
+			path := strings.TrimSuffix(conf.ExportDescription, ".txt") + ".txt"
+			ioutil.WriteFile(path, []byte(description), 0644)
+		}
+	}
+	if len(ext) == 0 || strings.ToLower(ext) == ".html" {
+		if len(html) == 0 {
+			log.Warnf("HTML description not available, but export was requested")
+		} else {
+			path := strings.TrimSuffix(conf.ExportDescription, ".html") + ".html"
+			ioutil.WriteFile(path, []byte(html), 0644)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testFlavorDimensions() map[int]FlavorDimension {
+	return map[int]FlavorDimension{
+		1: {Index: 1, Name: "tier", Flavors: map[string]string{"full": "full", "demo": "demo"}, Labels: []string{"full", "demo"},
+			SelectAllLabels: []string{"tier:*"}, SelectedFlavors: map[string]bool{}, NegatedFlavors: map[string]bool{}},
+		2: {Index: 2, Name: "color", Flavors: map[string]string{"blue": "blue", "teal": "teal"}, Labels: []string{"blue", "teal"},
+			SelectAllLabels: []string{"color:*", "all-colors"}, SelectedFlavors: map[string]bool{}, NegatedFlavors: map[string]bool{}},
+	}
+}
+
+func TestSelectFlavorsSelectAllLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       Conf
+		labels     []string
+		wantTier   map[string]bool
+		wantColors map[string]bool
+	}{
+		{"dimension label", Conf{}, []string{"tier:*"},
+			map[string]bool{"full": true, "demo": true}, map[string]bool{}},
+		{"select_all label", Conf{}, []string{"all-colors"},
+			map[string]bool{}, map[string]bool{"blue": true, "teal": true}},
+		{"global label", Conf{SelectAllLabel: "everything"}, []string{"everything"},
+			map[string]bool{"full": true, "demo": true}, map[string]bool{"blue": true, "teal": true}},
+		{"case sensitive", Conf{SelectAllLabel: "everything"}, []string{"Tier:*", "ALL-colors", "Everything"},
+			map[string]bool{}, map[string]bool{}},
+		{"ignore_label_case", Conf{SelectAllLabel: "everything", IgnoreLabelCase: true}, []string{"Tier:*", "ALL-colors"},
+			map[string]bool{"full": true, "demo": true}, map[string]bool{"blue": true, "teal": true}},
+		{"ignore_label_case global", Conf{SelectAllLabel: "everything", IgnoreLabelCase: true}, []string{"EVERYTHING"},
+			map[string]bool{"full": true, "demo": true}, map[string]bool{"blue": true, "teal": true}},
+	}
+	for _, test := range tests {
+		flavorDimensions := testFlavorDimensions()
+		selectFlavors(test.conf, PullRequest{Labels: test.labels}, flavorDimensions)
+		if got := flavorDimensions[1].SelectedFlavors; !reflect.DeepEqual(got, test.wantTier) {
+			t.Errorf("%v: got tier %v, want %v", test.name, got, test.wantTier)
+		}
+		if got := flavorDimensions[2].SelectedFlavors; !reflect.DeepEqual(got, test.wantColors) {
+			t.Errorf("%v: got colors %v, want %v", test.name, got, test.wantColors)
+		}
+	}
+}
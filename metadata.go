@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// the pull request properties that can be exported with pr_outputs
+var pullRequestOutputs = map[string]func(pullRequest PullRequest) string{
+	"PR_NUMBER": func(pullRequest PullRequest) string {
+		return strconv.Itoa(pullRequest.Number)
+	},
+	"PR_TITLE": func(pullRequest PullRequest) string {
+		return pullRequest.Title
+	},
+	"PR_URL": func(pullRequest PullRequest) string {
+		return pullRequest.Url
+	},
+	"PR_AUTHOR": func(pullRequest PullRequest) string {
+		return pullRequest.Author
+	},
+	"PR_SOURCE_BRANCH": func(pullRequest PullRequest) string {
+		return pullRequest.SourceBranch
+	},
+	"PR_TARGET_BRANCH": func(pullRequest PullRequest) string {
+		return pullRequest.TargetBranch
+	},
+	"PR_MILESTONE": func(pullRequest PullRequest) string {
+		return pullRequest.Milestone
+	},
+	"PR_MERGE_COMMIT": func(pullRequest PullRequest) string {
+		return pullRequest.MergeCommitSha
+	},
+	"PR_LABELS": func(pullRequest PullRequest) string {
+		return strings.Join(pullRequest.Labels, ",")
+	},
+}
+
+type PullRequestOutput struct {
+	Output string
+	EnvKey string
+}
+
+/*
+parses the pr_outputs specification "PR_TITLE,PR_LABELS=LABELS": a comma-separated list of outputs, each optionally
+exported under a different environment variable name.
+*/
+func getPullRequestOutputs(conf Conf) []PullRequestOutput {
+	var outputs []PullRequestOutput
+	for _, spec := range strings.Split(conf.PrOutputs, ",") {
+		spec = strings.TrimSpace(spec)
+		if len(spec) == 0 {
+			continue
+		}
+		output := PullRequestOutput{Output: spec, EnvKey: spec}
+		if pos := strings.Index(spec, "="); pos >= 0 {
+			output.Output = strings.TrimSpace(spec[:pos])
+			output.EnvKey = strings.TrimSpace(spec[pos+1:])
+		}
+		if pullRequestOutputs[output.Output] == nil {
+			fail("Invalid pull request output: %v. Allowed are: %v", output.Output, strings.Join(pullRequestOutputNames(), ", "))
+		}
+		if len(output.EnvKey) == 0 {
+			fail("pull request output specification does not include a variable name, check input: %v", spec)
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
+
+func pullRequestOutputNames() []string {
+	names := make([]string, 0, len(pullRequestOutputs))
+	for name := range pullRequestOutputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func exportPullRequestOutputs(outputs []PullRequestOutput, pullRequest *PullRequest) {
+	if len(outputs) == 0 {
+		return
+	}
+	if pullRequest == nil {
+		log.Warnf("No pull request resolved, pull request outputs are not exported")
+		return
+	}
+	for _, output := range outputs {
+		value := pullRequestOutputs[output.Output](*pullRequest)
+		fmt.Printf("%s = %s\n", output.EnvKey, value)
+		err := tools.ExportEnvironmentWithEnvman(output.EnvKey, value)
+		if err != nil {
+			fail("Failed to export environment variable: %v", err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// path_selection modes
+const (
+	pathSelectionUnion          = "union"
+	pathSelectionLabelsOverride = "labels_override"
+)
+
+// the number of changed files logged per path rule
+const maxLoggedPathMatches = 5
+
+// A PathRule selects a flavor if a changed file matches its glob, relative to the repository root:
+//   - "*" matches any text within a directory, "?" a single character other than "/"
+//   - "**" matches any number of directories: "src/demo/**", "**/*.kt"
+//   - a glob ending with "/" matches all files below the directory: "src/demo/"
+type PathRule struct {
+	Glob  string
+	Regex *regexp.Regexp
+	// the first label of the flavor
+	Label string
+}
+
+func newPathRule(glob string, label string) (PathRule, error) {
+	pattern := strings.TrimPrefix(glob, "/")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	expression := ""
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expression += "(?:.*/)?"
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expression += ".*"
+			i++
+		case pattern[i] == '*':
+			expression += "[^/]*"
+		case pattern[i] == '?':
+			expression += "[^/]"
+		default:
+			expression += regexp.QuoteMeta(pattern[i : i+1])
+		}
+	}
+	regex, err := regexp.Compile("^" + expression + "$")
+	if err != nil {
+		return PathRule{}, fmt.Errorf("invalid path glob %v: %v", glob, err)
+	}
+	return PathRule{Glob: glob, Regex: regex, Label: label}, nil
+}
+
+func validatePathSelection(conf Conf) {
+	switch conf.PathSelection {
+	case "", pathSelectionUnion, pathSelectionLabelsOverride:
+	default:
+		fail("Invalid path selection: %v. Allowed are: %v, %v", conf.PathSelection, pathSelectionUnion, pathSelectionLabelsOverride)
+	}
+}
+
+/*
+selects the flavors whose path rules match files changed between the merge base with the target branch and
+commit_hash, or HEAD without it. With path_selection "labels_override", dimensions with flavors selected by label
+are left as they are.
+
+Does nothing if no dimension has path rules, and warns if the changed files cannot be determined, e.g. for a shallow
+clone without the target branch.
+*/
+func selectFlavorsByPaths(conf Conf, flavorDimensions map[int]FlavorDimension, targetBranch string) {
+	hasPathRules := false
+	for _, flavorDimension := range flavorDimensions {
+		hasPathRules = hasPathRules || len(flavorDimension.PathRules) > 0
+	}
+	if !hasPathRules {
+		return
+	}
+	files, err := changedFiles(conf.CommitHash, targetBranch)
+	if err != nil {
+		log.Warnf("Path rules not applied: %v", err)
+		return
+	}
+	for _, flavorDimension := range sortedFlavorDimensions(flavorDimensions) {
+		if conf.PathSelection == pathSelectionLabelsOverride && len(flavorDimension.SelectedFlavors) > 0 {
+			continue
+		}
+		for _, rule := range flavorDimension.PathRules {
+			var matches []string
+			for _, file := range files {
+				if rule.Regex.MatchString(file) {
+					matches = append(matches, file)
+				}
+			}
+			if len(matches) == 0 {
+				continue
+			}
+			flavorDimension.SelectedFlavors[rule.Label] = true
+			examples := strings.Join(matches[:minInt(len(matches), maxLoggedPathMatches)], ", ")
+			if len(matches) > maxLoggedPathMatches {
+				examples += ", ..."
+			}
+			fmt.Printf("Path rule %s selects flavor %s of dimension %v, matching %d changed files: %s\n", rule.Glob,
+				flavorDimension.Flavors[rule.Label], flavorDimension, len(matches), examples)
+		}
+	}
+}
+
+/*
+the files changed between the merge base of the commit with the target branch and the commit. If the commit is on the
+target branch already, e.g. the merge commit of a pull request built after the merge, the merge base is the commit
+itself, so it is compared with its first parent instead.
+*/
+func changedFiles(commitHash string, targetBranch string) ([]string, error) {
+	if len(targetBranch) == 0 {
+		return nil, fmt.Errorf("target branch unknown")
+	}
+	if len(commitHash) == 0 {
+		commitHash = "HEAD"
+	}
+	commit, err := command.New("git", "rev-parse", "--verify", commitHash+"^{commit}").RunAndReturnTrimmedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("commit %v not found", commitHash)
+	}
+	mergeBase := ""
+	for _, ref := range []string{"origin/" + targetBranch, targetBranch} {
+		output, err := command.New("git", "merge-base", ref, commitHash).RunAndReturnTrimmedOutput()
+		if err == nil {
+			mergeBase = strings.TrimSpace(output)
+			break
+		}
+	}
+	if len(mergeBase) == 0 {
+		return nil, fmt.Errorf("no merge base of %v and %v found", targetBranch, commitHash)
+	}
+	base := mergeBase
+	if mergeBase == strings.TrimSpace(commit) {
+		base = commitHash + "^1"
+		log.Printf("%v is on %v already, comparing it with its first parent", commitHash, targetBranch)
+	}
+	output, err := command.New("git", "diff", "--name-only", base, commitHash).RunAndReturnTrimmedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed: %v", err)
+	}
+	var files []string
+	for _, file := range strings.Split(output, "\n") {
+		if file = strings.TrimSpace(file); len(file) > 0 {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		log.Warnf("No files changed between %v and %v, path rules select no flavors", base, commitHash)
+	} else {
+		log.Printf("%d files changed between %v and %v", len(files), base, commitHash)
+	}
+	return files, nil
+}
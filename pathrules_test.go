@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewPathRule(t *testing.T) {
+	tests := []struct {
+		glob    string
+		matches []string
+		others  []string
+	}{
+		{"src/demo/", []string{"src/demo/a.kt", "src/demo/res/b.xml"}, []string{"src/demo", "src/demos/a.kt"}},
+		{"/src/demo/**", []string{"src/demo/a.kt", "src/demo/res/b.xml"}, []string{"app/src/demo/a.kt"}},
+		{"**/*.kt", []string{"a.kt", "src/demo/a.kt"}, []string{"a.kts", "src/a.java"}},
+		{"src/*/a.kt", []string{"src/demo/a.kt"}, []string{"src/demo/res/a.kt"}},
+		{"src/demo?.kt", []string{"src/demo1.kt"}, []string{"src/demo/.kt", "src/demo12.kt"}},
+		{"build.gradle", []string{"build.gradle"}, []string{"app/build.gradle", "build_gradle"}},
+	}
+	for _, test := range tests {
+		rule, err := newPathRule(test.glob, "demo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range test.matches {
+			if !rule.Regex.MatchString(file) {
+				t.Errorf("%v does not match %v", test.glob, file)
+			}
+		}
+		for _, file := range test.others {
+			if rule.Regex.MatchString(file) {
+				t.Errorf("%v matches %v", test.glob, file)
+			}
+		}
+	}
+}
+
+// runs git in the directory, failing the test on errors, and returns its trimmed output
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func commitFile(t *testing.T, dir string, file string, message string) string {
+	path := filepath.Join(dir, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(message), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "add", file)
+	runGit(t, dir, "commit", "-q", "-m", message)
+	return runGit(t, dir, "rev-parse", "HEAD")
+}
+
+func TestChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	runGit(t, dir, "init", "-q", "-b", "main")
+	commitFile(t, dir, "build.gradle", "initial")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+	commitFile(t, dir, "src/demo/a.kt", "demo change")
+	feature := commitFile(t, dir, "src/full/b.kt", "full change")
+	runGit(t, dir, "checkout", "-q", "main")
+	commitFile(t, dir, "README.md", "main change")
+
+	want := []string{"src/demo/a.kt", "src/full/b.kt"}
+	files, err := changedFiles(feature, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got %v for the pull request commit, want %v", files, want)
+	}
+
+	runGit(t, dir, "merge", "-q", "--no-ff", "-m", "merge feature", "feature")
+	merge := runGit(t, dir, "rev-parse", "HEAD")
+	files, err = changedFiles(merge, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got %v for the merge commit on the target branch, want the merged changes %v", files, want)
+	}
+
+	if _, err := changedFiles(feature, "release"); err == nil {
+		t.Errorf("got no error for an unknown target branch")
+	}
+}
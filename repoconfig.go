@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+/*
+reads the config file at repo_config_path from the repository, so the variant configuration is versioned with the
+code. If commit_hash is available in the local clone, the file is read from that commit with git show. Otherwise,
+e.g. for a shallow clone without the commit, it is read from the working tree.
+
+Returns nil if the file does not exist.
+*/
+func loadRepoConfig(conf Conf) *Config {
+	if len(conf.RepoConfigPath) == 0 {
+		return nil
+	}
+	var content []byte
+	source := ""
+	if len(conf.CommitHash) > 0 && !filepath.IsAbs(conf.RepoConfigPath) && hasCommit(conf.CommitHash) {
+		// "./" resolves the path from the working directory, like the fallback, instead of the repository root
+		ref := conf.CommitHash + ":./" + filepath.ToSlash(filepath.Clean(conf.RepoConfigPath))
+		output, err := command.New("git", "show", ref).RunAndReturnTrimmedOutput()
+		if err != nil {
+			log.Printf("No repo config %v at commit %v", conf.RepoConfigPath, conf.CommitHash)
+			return nil
+		}
+		content = []byte(output)
+		source = ref
+	} else {
+		var err error
+		content, err = ioutil.ReadFile(conf.RepoConfigPath)
+		if os.IsNotExist(err) {
+			log.Printf("No repo config %v in the working tree", conf.RepoConfigPath)
+			return nil
+		}
+		if err != nil {
+			fail("Failed to read repo config: %v", err)
+		}
+		source = conf.RepoConfigPath
+	}
+	log.Printf("Using repo config %v", source)
+	config, err := parseConfig(source, content)
+	if err != nil {
+		fail("%v", err)
+	}
+	return &config
+}
+
+// whether the commit is present in the git repository of the working directory
+func hasCommit(commitHash string) bool {
+	output, err := command.New("git", "cat-file", "-t", commitHash).RunAndReturnTrimmedOutput()
+	return err == nil && strings.TrimSpace(output) == "commit"
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const defaultApiTimeout = 30
+const defaultApiRetryMaxWait = 60
+
+// base delay of the exponential backoff, doubled with every retry
+var retryBaseDelay = time.Second
+
+/*
+sends a request, retrying network errors, server errors (5xx) and rate limited responses (429, or 403 with an
+exhausted rate limit) up to api_retries times.
+
+The delay before a retry is taken from the Retry-After header, the reset time of an exhausted rate limit
+(X-RateLimit-Reset on GitHub, RateLimit-Reset on GitLab) or else an exponential backoff with jitter. If the server
+asks to wait longer than api_retry_max_wait seconds, the step fails instead of waiting.
+
+newRequest is called for every attempt, as a request body cannot be sent twice. Returns the last response with its
+body read; responses that are not retried are returned regardless of their status code.
+*/
+func sendRequest(conf Conf, description string, newRequest func() (*http.Request, error)) (*http.Response, []byte) {
+	response, body, err := retryRequest(httpClient(conf), conf, description, newRequest)
+	if err != nil {
+		fail("%v\n", err)
+	}
+	return response, body
+}
+
+// the retry loop of sendRequest, returning an error instead of failing the step
+func retryRequest(client *http.Client, conf Conf, description string, newRequest func() (*http.Request, error)) (*http.Response, []byte, error) {
+	maxWait := time.Duration(conf.ApiRetryMaxWait) * time.Second
+	if maxWait <= 0 {
+		maxWait = defaultApiRetryMaxWait * time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		request, err := newRequest()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create request: %v", err)
+		}
+		response, err := client.Do(request)
+		var body []byte
+		if err == nil {
+			buf := new(bytes.Buffer)
+			_, err = buf.ReadFrom(response.Body)
+			response.Body.Close()
+			body = buf.Bytes()
+		}
+
+		var reason string
+		var delay time.Duration
+		if err != nil {
+			reason = err.Error()
+		} else if isRateLimited(response, body) {
+			reason = "rate limit exceeded (" + response.Status + ")"
+			delay = rateLimitDelay(response)
+		} else if response.StatusCode >= 500 {
+			reason = response.Status
+			delay = retryAfterDelay(response)
+		} else {
+			return response, body, nil
+		}
+
+		if attempt >= conf.ApiRetries {
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to send %v: %v", description, err)
+			}
+			if conf.ApiRetries > 0 {
+				log.Warnf("%v failed: %v. Giving up after %d retries", description, reason, conf.ApiRetries)
+			}
+			return response, body, nil
+		}
+		if delay > maxWait {
+			return nil, nil, fmt.Errorf("%v failed: %v. The server asks to wait %v, which exceeds the maximum of %v (api_retry_max_wait)",
+				description, reason, delay.Round(time.Second), maxWait)
+		}
+		if delay <= 0 {
+			delay = backoffDelay(attempt, maxWait)
+		}
+		log.Warnf("%v failed: %v. Retrying in %v (retry %d of %d)", description, reason, delay.Round(time.Millisecond), attempt+1, conf.ApiRetries)
+		time.Sleep(delay)
+	}
+}
+
+func isRateLimited(response *http.Response, body []byte) bool {
+	if response.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if response.StatusCode != http.StatusForbidden {
+		return false
+	}
+	// github answers exceeded primary and secondary rate limits with 403
+	return response.Header.Get("X-RateLimit-Remaining") == "0" || len(response.Header.Get("Retry-After")) > 0 ||
+		strings.Contains(strings.ToLower(string(body)), "rate limit")
+}
+
+func rateLimitDelay(response *http.Response) time.Duration {
+	if delay := retryAfterDelay(response); delay > 0 {
+		return delay
+	}
+	if response.Header.Get("X-RateLimit-Remaining") == "0" {
+		if delay := resetDelay(response.Header.Get("X-RateLimit-Reset")); delay > 0 {
+			return delay
+		}
+	}
+	return resetDelay(response.Header.Get("RateLimit-Reset"))
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func retryAfterDelay(response *http.Response) time.Duration {
+	retryAfter := strings.TrimSpace(response.Header.Get("Retry-After"))
+	if len(retryAfter) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// rate limit reset headers hold a unix timestamp (GitHub, GitLab) or, as in the IETF draft, a number of seconds
+func resetDelay(reset string) time.Duration {
+	value, err := strconv.ParseInt(strings.TrimSpace(reset), 10, 64)
+	if err != nil || value <= 0 {
+		return 0
+	}
+	if value < 1000000000 {
+		return time.Duration(value) * time.Second
+	}
+	delay := time.Until(time.Unix(value, 0))
+	if delay <= 0 {
+		return 0
+	}
+	// the reset timestamp has a resolution of seconds
+	return delay + time.Second
+}
+
+// exponential backoff with jitter: a random delay between half and the full doubled base delay
+func backoffDelay(attempt int, maxWait time.Duration) time.Duration {
+	delay := time.Duration(float64(retryBaseDelay) * math.Pow(2, float64(attempt)))
+	if delay > maxWait {
+		delay = maxWait
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func apiTimeout(conf Conf) time.Duration {
+	if conf.ApiTimeout <= 0 {
+		return defaultApiTimeout * time.Second
+	}
+	return time.Duration(conf.ApiTimeout) * time.Second
+}
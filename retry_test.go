@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a server answering with the given responses in turn, repeating the last one
+func flakyServer(t *testing.T, responses ...func(http.ResponseWriter)) (*httptest.Server, *int) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		respond := responses[minInt(requests, len(responses)-1)]
+		requests++
+		respond(writer)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func status(code int, headers ...string) func(http.ResponseWriter) {
+	return func(writer http.ResponseWriter) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			writer.Header().Set(headers[i], headers[i+1])
+		}
+		writer.WriteHeader(code)
+		writer.Write([]byte(http.StatusText(code)))
+	}
+}
+
+func get(url string) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		return http.NewRequest("GET", url, nil)
+	}
+}
+
+func fastRetries(t *testing.T) {
+	previous := retryBaseDelay
+	retryBaseDelay = time.Millisecond
+	t.Cleanup(func() { retryBaseDelay = previous })
+}
+
+func TestRetryRequestRetriesServerErrors(t *testing.T) {
+	fastRetries(t)
+	server, requests := flakyServer(t, status(502), status(502), status(200))
+
+	response, body, err := retryRequest(server.Client(), Conf{ApiRetries: 3}, "test request", get(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 || string(body) != "OK" {
+		t.Errorf("got %v %q, want 200 OK", response.Status, body)
+	}
+	if *requests != 3 {
+		t.Errorf("got %d requests, want 3", *requests)
+	}
+}
+
+func TestRetryRequestGivesUpAfterRetries(t *testing.T) {
+	fastRetries(t)
+	server, requests := flakyServer(t, status(502))
+
+	response, _, err := retryRequest(server.Client(), Conf{ApiRetries: 2}, "test request", get(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 502 {
+		t.Errorf("got %v, want the last response 502", response.Status)
+	}
+	if *requests != 3 {
+		t.Errorf("got %d requests, want 3", *requests)
+	}
+}
+
+func TestRetryRequestDoesNotRetryClientErrors(t *testing.T) {
+	fastRetries(t)
+	server, requests := flakyServer(t, status(404), status(200))
+
+	response, _, err := retryRequest(server.Client(), Conf{ApiRetries: 3}, "test request", get(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 404 || *requests != 1 {
+		t.Errorf("got %v after %d requests, want 404 after 1", response.Status, *requests)
+	}
+}
+
+func TestRetryRequestHonorsRetryAfter(t *testing.T) {
+	fastRetries(t)
+	server, requests := flakyServer(t, status(429, "Retry-After", "1"), status(200))
+
+	start := time.Now()
+	response, _, err := retryRequest(server.Client(), Conf{ApiRetries: 1}, "test request", get(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != 200 || *requests != 2 {
+		t.Errorf("got %v after %d requests, want 200 after 2", response.Status, *requests)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the Retry-After of 1s", elapsed)
+	}
+}
+
+func TestRetryRequestFailsIfRetryAfterExceedsMaxWait(t *testing.T) {
+	fastRetries(t)
+	server, requests := flakyServer(t, status(429, "Retry-After", "120"))
+
+	_, _, err := retryRequest(server.Client(), Conf{ApiRetries: 3, ApiRetryMaxWait: 10}, "test request", get(server.URL))
+	if err == nil || !strings.Contains(err.Error(), "api_retry_max_wait") {
+		t.Errorf("got error %v, want one about api_retry_max_wait", err)
+	}
+	if *requests != 1 {
+		t.Errorf("got %d requests, want 1", *requests)
+	}
+}
+
+func TestRetryRequestWaitsForRateLimitReset(t *testing.T) {
+	fastRetries(t)
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	server, _ := flakyServer(t, status(403, "X-RateLimit-Remaining", "0", "X-RateLimit-Reset", reset))
+
+	_, _, err := retryRequest(server.Client(), Conf{ApiRetries: 3, ApiRetryMaxWait: 60}, "test request", get(server.URL))
+	if err == nil || !strings.Contains(err.Error(), "rate limit exceeded") {
+		t.Errorf("got error %v, want the rate limit reset to exceed the maximum wait", err)
+	}
+}
+
+func TestRetryRequestReportsNetworkErrors(t *testing.T) {
+	fastRetries(t)
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	_, _, err := retryRequest(server.Client(), Conf{ApiRetries: 1}, "test request", get(server.URL))
+	if err == nil || !strings.Contains(err.Error(), "failed to send test request") {
+		t.Errorf("got error %v, want a send failure", err)
+	}
+}
+
+func TestIsRateLimited(t *testing.T) {
+	tests := []struct {
+		code    int
+		headers http.Header
+		body    string
+		want    bool
+	}{
+		{429, http.Header{}, "", true},
+		{403, http.Header{"X-Ratelimit-Remaining": {"0"}}, "", true},
+		{403, http.Header{"Retry-After": {"30"}}, "", true},
+		{403, http.Header{}, `{"message": "You have exceeded a secondary rate limit"}`, true},
+		{403, http.Header{}, `{"message": "Resource not accessible by integration"}`, false},
+		{502, http.Header{}, "", false},
+	}
+	for _, test := range tests {
+		response := &http.Response{StatusCode: test.code, Header: test.headers}
+		if got := isRateLimited(response, []byte(test.body)); got != test.want {
+			t.Errorf("isRateLimited(%d, %v, %q) = %v, want %v", test.code, test.headers, test.body, got, test.want)
+		}
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	inTenSeconds := strconv.FormatInt(time.Now().Add(10*time.Second).Unix(), 10)
+	tests := []struct {
+		name    string
+		headers http.Header
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"retry after seconds", http.Header{"Retry-After": {"7"}}, 7 * time.Second, 7 * time.Second},
+		{"github reset timestamp", http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {inTenSeconds}}, 9 * time.Second, 12 * time.Second},
+		{"github reset with remaining requests", http.Header{"X-Ratelimit-Remaining": {"5"}, "X-Ratelimit-Reset": {inTenSeconds}}, 0, 0},
+		{"gitlab reset timestamp", http.Header{"Ratelimit-Reset": {inTenSeconds}}, 9 * time.Second, 12 * time.Second},
+		{"reset seconds", http.Header{"Ratelimit-Reset": {"30"}}, 30 * time.Second, 30 * time.Second},
+		{"no headers", http.Header{}, 0, 0},
+	}
+	for _, test := range tests {
+		delay := rateLimitDelay(&http.Response{Header: test.headers})
+		if delay < test.min || delay > test.max {
+			t.Errorf("%v: got %v, want between %v and %v", test.name, delay, test.min, test.max)
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		full := retryBaseDelay << uint(attempt)
+		if full > time.Minute {
+			full = time.Minute
+		}
+		delay := backoffDelay(attempt, time.Minute)
+		if delay < full/2 || delay > full {
+			t.Errorf("attempt %d: got %v, want between %v and %v", attempt, delay, full/2, full)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// data of a template pattern, evaluated once per variant
+type TemplateData struct {
+	// the flavors of the variant by dimension name and index ("1", "2", ...)
+	Flavor map[string]string
+	// the flavors of the variant in the order of the dimensions
+	Flavors []string
+	// all labels of the pull request, sorted
+	Labels []string
+	// the pull request, empty if none was found
+	PR PullRequest
+}
+
+// what is shared by all variants: the dimensions, the pull request and its labels
+type TemplateContext struct {
+	Dimensions  []FlavorDimension
+	Labels      []string
+	PullRequest PullRequest
+}
+
+func newTemplateContext(flavorDimensions map[int]FlavorDimension, pullRequest *PullRequest, labels map[string]bool) TemplateContext {
+	context := TemplateContext{Dimensions: sortedFlavorDimensions(flavorDimensions)}
+	if pullRequest != nil {
+		context.PullRequest = *pullRequest
+	}
+	for label := range labels {
+		context.Labels = append(context.Labels, label)
+	}
+	sort.Strings(context.Labels)
+	return context
+}
+
+func (context TemplateContext) data(variant Variant) TemplateData {
+	data := TemplateData{
+		Flavor:  make(map[string]string),
+		Flavors: variant,
+		Labels:  context.Labels,
+		PR:      context.PullRequest,
+	}
+	for i, flavorDimension := range context.Dimensions {
+		data.Flavor[strconv.Itoa(flavorDimension.Index)] = variant[i]
+		if len(flavorDimension.Name) > 0 {
+			data.Flavor[flavorDimension.Name] = variant[i]
+		}
+	}
+	return data
+}
+
+/*
+parses variant_patterns in template mode: one "{key}={template}" per line, optionally followed by ";{separator}"
+after the last action of the template
+*/
+func parseTemplatePatterns(conf Conf) []ConfigOutput {
+	var outputs []ConfigOutput
+	for _, patternSpec := range strings.Split(conf.VariantPatterns, "\n") {
+		if len(strings.TrimSpace(patternSpec)) == 0 {
+			continue
+		}
+		equalsPos := strings.Index(patternSpec, "=")
+		if equalsPos < 0 {
+			fail("invalid variant pattern specification: %v\nExpected '{variable}={template}[;{separator}]", patternSpec)
+		}
+		key := strings.TrimSpace(patternSpec[:equalsPos])
+		if len(key) == 0 {
+			fail("variant pattern specification does not include a key, check input: %v", patternSpec)
+		}
+		pattern := strings.TrimSpace(patternSpec[equalsPos+1:])
+
+		separator := " "
+		// a ";" inside the template belongs to it, only one after the last action starts the separator
+		tailPos := strings.LastIndex(pattern, "}}") + 1
+		if separatorPos := strings.Index(pattern[tailPos:], ";"); separatorPos >= 0 {
+			separator = pattern[tailPos+separatorPos+1:]
+			if len(separator) == 0 {
+				separator = " "
+			}
+			pattern = strings.TrimSpace(pattern[:tailPos+separatorPos])
+		}
+
+		outputs = append(outputs, ConfigOutput{Key: key, Pattern: pattern, Separator: separator, Source: "input: " + patternSpec})
+	}
+	return outputs
+}
+
+// parses a template and checks it by rendering it for the default variant, so syntax errors and references to
+// undefined dimensions fail the step before anything is fetched
+func parseTemplate(key string, pattern string, source string, flavorDimensions map[int]FlavorDimension) *template.Template {
+	tmpl, err := template.New(key).Funcs(templateFuncs).Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		fail("invalid variant pattern template: %v, check %v", err, source)
+	}
+	variant, context := defaultVariant(flavorDimensions)
+	if _, err := renderTemplate(VariantPattern{Template: tmpl}, context, variant); err != nil {
+		fail("invalid variant pattern template: %v, check %v", err, source)
+	}
+	return tmpl
+}
+
+// a variant of the default flavors, or the first declared ones, to check templates with
+func defaultVariant(flavorDimensions map[int]FlavorDimension) (Variant, TemplateContext) {
+	context := TemplateContext{Dimensions: sortedFlavorDimensions(flavorDimensions)}
+	var variant Variant
+	for _, flavorDimension := range context.Dimensions {
+		label := ""
+		if len(flavorDimension.DefaultFlavors) > 0 {
+			label = flavorDimension.DefaultFlavors[0]
+		} else if len(flavorDimension.Labels) > 0 {
+			label = flavorDimension.Labels[0]
+		}
+		variant = append(variant, flavorDimension.Flavors[label])
+	}
+	return variant, context
+}
+
+func renderTemplate(variantPattern VariantPattern, context TemplateContext, variant Variant) (string, error) {
+	var rendered bytes.Buffer
+	if err := variantPattern.Template.Execute(&rendered, context.data(variant)); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(rendered.String()), nil
+}
+
+// the case modifiers of placeholders plus helpers for lists
+var templateFuncs = template.FuncMap{
+	"raw":    caseModifiers["raw"],
+	"lower":  caseModifiers["lower"],
+	"upper":  caseModifiers["upper"],
+	"kebab":  caseModifiers["kebab"],
+	"snake":  caseModifiers["snake"],
+	"pascal": caseModifiers["pascal"],
+	"camel":  caseModifiers["camel"],
+	// join "," .Flavors
+	"join": func(separator string, values []string) string {
+		return strings.Join(values, separator)
+	},
+	// contains .Labels "beta", contains .PR.Title "WIP"
+	"contains": func(collection interface{}, value string) (bool, error) {
+		switch collection := collection.(type) {
+		case string:
+			return strings.Contains(collection, value), nil
+		case []string:
+			for _, element := range collection {
+				if element == value {
+					return true, nil
+				}
+			}
+			return false, nil
+		case nil:
+			return false, nil
+		}
+		return false, fmt.Errorf("contains: unsupported type %v", reflect.TypeOf(collection))
+	},
+}
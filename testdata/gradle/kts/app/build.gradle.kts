@@ -0,0 +1,20 @@
+android {
+    flavorDimensions += listOf("tier")
+    productFlavors {
+        create("full") {
+            dimension = "tier"
+        }
+        register("demo") {
+            dimension = "tier"
+            buildConfigField("String", "URL", "\"https://example.com/{x}\"")
+        }
+    }
+    buildTypes {
+        getByName("release") {
+            isMinifyEnabled = true
+        }
+        create("benchmark") {
+            initWith(getByName("release"))
+        }
+    }
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+type VariantPattern struct {
+	Key       string
+	Pattern   string
+	Separator string
+	Parts     []PatternPart
+	// the parsed pattern if the template engine is used, see template.go
+	Template *template.Template
+}
+
+// literal text or, if Dimension is set, the placeholder for the flavor of the dimension with that index
+type PatternPart struct {
+	Text      string
+	Dimension int
+	Modifier  string
+}
+
+// a combination of one flavor per dimension, in the order of the dimensions
+type Variant []string
+
+/*
+"#1", "#12" reference a dimension by index, "#{tier}" or "#{1}" by name or index. A case modifier may follow:
+"#1:kebab", "#{tier}:kebab" or "#{tier:kebab}". A lower case word after the colon that is not a modifier is kept as
+literal text.
+*/
+var placeholderRegex = regexp.MustCompile(`#\{([^}]*)\}(:([a-z]+))?|#(\d+)(:([a-z]+))?`)
+
+// checks the pattern engine input, before patterns from the inputs or config files are parsed
+func validatePatternEngine(conf Conf) {
+	switch conf.PatternEngine {
+	case "", "placeholder", "template":
+	default:
+		fail("Invalid pattern engine: %v. Allowed are: placeholder, template", conf.PatternEngine)
+	}
+}
+
+// parses the variant_patterns input into outputs as in the config file
+func parseVariantPatterns(conf Conf) []ConfigOutput {
+	if conf.PatternEngine == "template" {
+		return parseTemplatePatterns(conf)
+	}
+
+	var outputs []ConfigOutput
+
+	for _, patternSpec := range strings.Split(conf.VariantPatterns, "|") {
+		parts := strings.Split(patternSpec, "=")
+		if len(parts) != 2 {
+			fail("invalid variant pattern specification: %v\nExpected '{variable}={pattern}[;{separator}]", patternSpec)
+		}
+
+		key := strings.TrimSpace(parts[0])
+		if len(key) == 0 {
+			fail("variant pattern specification does not include a key, check input: %v", patternSpec)
+		}
+		pattern := strings.TrimSpace(parts[1])
+
+		separator := " "
+		separatorPos := strings.Index(pattern, `;`)
+		if separatorPos > 0 {
+			separator = pattern[separatorPos+1:]
+			if len(separator) == 0 {
+				separator = " "
+			}
+			pattern = strings.TrimSpace(pattern[:separatorPos])
+		}
+
+		outputs = append(outputs, ConfigOutput{Key: key, Pattern: pattern, Separator: separator, Source: "input: " + patternSpec})
+	}
+	return outputs
+}
+
+// parses a pattern with the configured engine. source tells where the pattern was given, for error messages.
+func newVariantPattern(conf Conf, key string, pattern string, separator string, source string, flavorDimensions map[int]FlavorDimension) VariantPattern {
+	variantPattern := VariantPattern{Key: key, Pattern: pattern, Separator: separator}
+	if conf.PatternEngine == "template" {
+		variantPattern.Template = parseTemplate(key, pattern, source, flavorDimensions)
+	} else {
+		variantPattern.Parts = parsePattern(pattern, source, flavorDimensions)
+	}
+	return variantPattern
+}
+
+// splits a pattern into literal text and placeholders, failing on references to undefined dimensions
+func parsePattern(pattern string, source string, flavorDimensions map[int]FlavorDimension) []PatternPart {
+	var parts []PatternPart
+	hasPlaceholder := false
+	pos := 0
+	for _, match := range placeholderRegex.FindAllStringSubmatchIndex(pattern, -1) {
+		reference, modifier := "", ""
+		end := match[1]
+		if match[2] >= 0 {
+			reference = pattern[match[2]:match[3]]
+			if colonPos := strings.Index(reference, ":"); colonPos >= 0 {
+				modifier = strings.TrimSpace(reference[colonPos+1:])
+				reference = reference[:colonPos]
+			}
+			reference = strings.TrimSpace(reference)
+			if match[6] >= 0 && caseModifiers[pattern[match[6]:match[7]]] != nil {
+				modifier = pattern[match[6]:match[7]]
+			} else if match[4] >= 0 {
+				end = match[4]
+			}
+		} else {
+			reference = pattern[match[8]:match[9]]
+			if match[12] >= 0 && caseModifiers[pattern[match[12]:match[13]]] != nil {
+				modifier = pattern[match[12]:match[13]]
+			} else if match[10] >= 0 {
+				end = match[10]
+			}
+		}
+		dimension := findFlavorDimension(reference, flavorDimensions)
+		if dimension == 0 {
+			fail("variant pattern references undefined flavor dimension %v, check %v", reference, source)
+		}
+		if _, ok := caseModifiers[modifier]; len(modifier) > 0 && !ok {
+			fail("Invalid placeholder modifier: %v. Allowed are: %v, check %v", modifier,
+				strings.Join(caseModifierNames(), ", "), source)
+		}
+		if match[0] > pos {
+			parts = append(parts, PatternPart{Text: pattern[pos:match[0]]})
+		}
+		parts = append(parts, PatternPart{Dimension: dimension, Modifier: modifier})
+		hasPlaceholder = true
+		pos = end
+	}
+	if !hasPlaceholder {
+		fail("variant pattern does not include a placeholder #<n> or #{<name>}, check %v", source)
+	}
+	if pos < len(pattern) {
+		parts = append(parts, PatternPart{Text: pattern[pos:]})
+	}
+	return parts
+}
+
+// the index of the dimension referenced by name or index, 0 if there is none
+func findFlavorDimension(reference string, flavorDimensions map[int]FlavorDimension) int {
+	if index, err := strconv.Atoi(reference); err == nil {
+		if _, ok := flavorDimensions[index]; ok {
+			return index
+		}
+		return 0
+	}
+	for index, flavorDimension := range flavorDimensions {
+		if len(flavorDimension.Name) > 0 && flavorDimension.Name == reference {
+			return index
+		}
+	}
+	return 0
+}
+
+func sortedFlavorDimensions(flavorDimensions map[int]FlavorDimension) []FlavorDimension {
+	dimensions := make([]FlavorDimension, 0, len(flavorDimensions))
+	for _, flavorDimension := range flavorDimensions {
+		dimensions = append(dimensions, flavorDimension)
+	}
+	sort.Slice(dimensions, func(i, j int) bool {
+		return dimensions[i].Index < dimensions[j].Index
+	})
+	return dimensions
+}
+
+func (flavorDimension FlavorDimension) hasFlavor(flavor string) bool {
+	for _, name := range flavorDimension.Flavors {
+		if name == flavor {
+			return true
+		}
+	}
+	return false
+}
+
+// cardinalities of a dimension
+const (
+	selectMulti      = "multi"
+	selectSingle     = "single"
+	selectAtLeastOne = "at_least_one"
+)
+
+/*
+the flavors selected by label or else the default flavors, in declaration order, without the negated ones. The step
+fails if negation leaves no flavor. A single-select dimension with labels for several flavors fails, unless its
+priority decides. An at-least-one dimension fails without labels.
+*/
+func selectedFlavors(flavorDimension FlavorDimension) []string {
+	var flavors []string
+	var labels []string
+	var negated []string
+	for _, label := range flavorDimension.Labels {
+		if flavorDimension.NegatedFlavors[label] {
+			negated = appendUnique(negated, flavorDimension.Flavors[label])
+		}
+	}
+	anySelected := false
+	for _, label := range flavorDimension.Labels {
+		if !flavorDimension.SelectedFlavors[label] {
+			continue
+		}
+		anySelected = true
+		if containsString(negated, flavorDimension.Flavors[label]) {
+			continue
+		}
+		flavors = appendUnique(flavors, flavorDimension.Flavors[label])
+		labels = append(labels, label)
+	}
+	if anySelected && len(flavors) == 0 {
+		fail("No flavor of dimension %v left after negating %v, aborting...", flavorDimension, strings.Join(negated, ", "))
+	}
+	if len(flavors) > 1 && flavorDimension.Select == selectSingle {
+		winner := ""
+		for _, flavor := range flavorDimension.Priority {
+			if containsString(flavors, flavor) {
+				winner = flavor
+				break
+			}
+		}
+		if len(winner) == 0 {
+			fail("Conflicting labels %v for flavor dimension %v, which allows a single flavor only",
+				strings.Join(labels, ", "), flavorDimension)
+		}
+		log.Warnf("Conflicting labels %v for flavor dimension %v, which allows a single flavor only. Selecting %v by priority",
+			strings.Join(labels, ", "), flavorDimension, winner)
+		flavors = []string{winner}
+	}
+	if len(flavors) == 0 {
+		if flavorDimension.Select == selectAtLeastOne {
+			fail("No label for flavor dimension %v found, which requires at least one, aborting...", flavorDimension)
+		}
+		if len(flavorDimension.DefaultFlavors) == 0 {
+			fail("No label for flavor dimension %v found and no default set, aborting...", flavorDimension)
+		}
+		for _, flavor := range flavorDimension.flavorNames(flavorDimension.DefaultFlavors) {
+			if !containsString(negated, flavor) {
+				flavors = append(flavors, flavor)
+			}
+		}
+		if len(flavors) == 0 {
+			fail("No flavor of dimension %v left after negating the defaults %v, aborting...", flavorDimension,
+				strings.Join(negated, ", "))
+		}
+		if len(negated) > 0 {
+			fmt.Printf("No label for flavor dimension %v found, defaulting to %s without the negated %s\n", flavorDimension,
+				strings.Join(flavors, ", "), strings.Join(negated, ", "))
+		} else {
+			fmt.Printf("No label for flavor dimension %v found, defaulting to %s\n", flavorDimension, strings.Join(flavors, ", "))
+		}
+	}
+	return flavors
+}
+
+// all combinations of the selected flavors. The first dimension varies slowest.
+func getVariants(flavorDimensions map[int]FlavorDimension) []Variant {
+	variants := []Variant{{}}
+	for _, flavorDimension := range sortedFlavorDimensions(flavorDimensions) {
+		flavors := selectedFlavors(flavorDimension)
+		var combined []Variant
+		for _, variant := range variants {
+			for _, flavor := range flavors {
+				next := make(Variant, len(variant), len(variant)+1)
+				copy(next, variant)
+				combined = append(combined, append(next, flavor))
+			}
+		}
+		variants = combined
+	}
+	return variants
+}
+
+// a combination rule of the config: flavors by dimension index
+type CombinationRule struct {
+	Flavors map[int][]string
+	Source  string
+}
+
+func (rule CombinationRule) matches(variant Variant) bool {
+	for dimension, flavors := range rule.Flavors {
+		if !containsString(flavors, variant[dimension-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// drops the variants not matching any include rule, if there are any, and those matching an exclude rule
+func filterVariants(variants []Variant, include []CombinationRule, exclude []CombinationRule) []Variant {
+	var filtered []Variant
+	for _, variant := range variants {
+		reason := ""
+		if len(include) > 0 {
+			reason = "not matched by any include rule"
+			for _, rule := range include {
+				if rule.matches(variant) {
+					reason = ""
+					break
+				}
+			}
+		}
+		for _, rule := range exclude {
+			if len(reason) == 0 && rule.matches(variant) {
+				reason = "excluded by the rule at " + rule.Source
+			}
+		}
+		if len(reason) > 0 {
+			log.Printf("Dropping variant %v: %v", strings.Join(variant, "+"), reason)
+			continue
+		}
+		filtered = append(filtered, variant)
+	}
+	if len(filtered) == 0 {
+		fail("No variants left after applying the combination rules to %d variants", len(variants))
+	}
+	return filtered
+}
+
+/*
+sorts the variants according to variant_order:
+
+"declaration": by the order of the flavors in variant_labels, the first dimension varying slowest
+"alphabetical": by flavor names, the first dimension varying slowest
+"priority": variants with the first flavor in variant_priority first, then those with the second one and so on,
+ties kept in declaration order
+*/
+func orderVariants(conf Conf, variants []Variant, flavorDimensions map[int]FlavorDimension) {
+	switch conf.VariantOrder {
+	case "", "declaration":
+	case "alphabetical":
+		sort.SliceStable(variants, func(i, j int) bool {
+			for dimension := range variants[i] {
+				if variants[i][dimension] != variants[j][dimension] {
+					return variants[i][dimension] < variants[j][dimension]
+				}
+			}
+			return false
+		})
+	case "priority":
+		priorities := getVariantPriorities(conf, flavorDimensions)
+		sort.SliceStable(variants, func(i, j int) bool {
+			for _, flavor := range priorities {
+				hasFlavorI := variants[i].contains(flavor)
+				if hasFlavorI != variants[j].contains(flavor) {
+					return hasFlavorI
+				}
+			}
+			return false
+		})
+	default:
+		fail("Invalid variant order: %v. Allowed are: declaration, alphabetical, priority", conf.VariantOrder)
+	}
+}
+
+// flavor names listed in variant_priority. Entries may also be labels, which are resolved to their flavor.
+func getVariantPriorities(conf Conf, flavorDimensions map[int]FlavorDimension) []string {
+	var priorities []string
+	for _, entry := range strings.Split(conf.VariantPriority, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		flavor := ""
+		for _, flavorDimension := range sortedFlavorDimensions(flavorDimensions) {
+			if name, ok := flavorDimension.Flavors[entry]; ok {
+				flavor = name
+				break
+			}
+			for _, name := range flavorDimension.Flavors {
+				if name == entry {
+					flavor = name
+				}
+			}
+		}
+		if len(flavor) == 0 {
+			fail("Unknown flavor in variant priority: %v, check input: %v", entry, conf.VariantPriority)
+		}
+		priorities = append(priorities, flavor)
+	}
+	if len(priorities) == 0 {
+		fail("variant_order is priority, but variant_priority lists no flavors")
+	}
+	return priorities
+}
+
+func (variant Variant) contains(flavor string) bool {
+	for _, name := range variant {
+		if name == flavor {
+			return true
+		}
+	}
+	return false
+}
+
+func generateEnvironmentVariable(variantPattern VariantPattern, variants []Variant, context TemplateContext) {
+	var values []string
+	for _, variant := range variants {
+		value := ""
+		if variantPattern.Template != nil {
+			var err error
+			value, err = renderTemplate(variantPattern, context, variant)
+			if err != nil {
+				fail("Failed to render variant pattern %v: %v", variantPattern.Key, err)
+			}
+			// templates may decide not to output anything for a variant
+			if len(value) == 0 {
+				continue
+			}
+		} else {
+			value = renderVariant(variantPattern, variant)
+		}
+		// patterns not referencing every dimension yield duplicates
+		values = appendUnique(values, value)
+	}
+	variantsString := strings.Join(values, variantPattern.Separator)
+	fmt.Printf("%s = %s\n", variantPattern.Key, variantsString)
+	err := tools.ExportEnvironmentWithEnvman(variantPattern.Key, variantsString)
+	if err != nil {
+		fail("Failed to export environment variable: %v", err)
+	}
+}
+
+/*
+a placeholder with a case modifier is replaced by the flavor in that case. Without a modifier, a placeholder at the
+start of the pattern is replaced verbatim and all others by the capitalized flavor, as Gradle names variants.
+*/
+func renderVariant(variantPattern VariantPattern, variant Variant) string {
+	var rendered strings.Builder
+	for i, part := range variantPattern.Parts {
+		if part.Dimension == 0 {
+			rendered.WriteString(part.Text)
+			continue
+		}
+		flavor := variant[part.Dimension-1]
+		if len(part.Modifier) > 0 {
+			rendered.WriteString(caseModifiers[part.Modifier](flavor))
+		} else if i == 0 {
+			rendered.WriteString(flavor)
+		} else {
+			rendered.WriteString(capitalize(flavor))
+		}
+	}
+	return rendered.String()
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
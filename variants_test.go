@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	flavorDimensions := map[int]FlavorDimension{
+		1:  {Index: 1, Name: "tier"},
+		2:  {Index: 2},
+		12: {Index: 12, Name: "color"},
+	}
+	tests := []struct {
+		pattern string
+		want    []PatternPart
+	}{
+		{"assemble#1Release", []PatternPart{{Text: "assemble"}, {Dimension: 1}, {Text: "Release"}}},
+		{"#12Release", []PatternPart{{Dimension: 12}, {Text: "Release"}}},
+		{"#1#2", []PatternPart{{Dimension: 1}, {Dimension: 2}}},
+		{"#{1}2", []PatternPart{{Dimension: 1}, {Text: "2"}}},
+		{"#{tier}-#{color}", []PatternPart{{Dimension: 1}, {Text: "-"}, {Dimension: 12}}},
+		{"#{ tier }", []PatternPart{{Dimension: 1}}},
+		{"#1:kebab", []PatternPart{{Dimension: 1, Modifier: "kebab"}}},
+		{"#{tier}:kebab", []PatternPart{{Dimension: 1, Modifier: "kebab"}}},
+		{"#{tier:kebab}", []PatternPart{{Dimension: 1, Modifier: "kebab"}}},
+		{"#{tier:kebab}:upper", []PatternPart{{Dimension: 1, Modifier: "upper"}}},
+		{"#{tier:kebab}:release", []PatternPart{{Dimension: 1, Modifier: "kebab"}, {Text: ":release"}}},
+		{"#1:release", []PatternPart{{Dimension: 1}, {Text: ":release"}}},
+		{"#{color}:Debug", []PatternPart{{Dimension: 12}, {Text: ":Debug"}}},
+		{"#12:upper_#2", []PatternPart{{Dimension: 12, Modifier: "upper"}, {Text: "_"}, {Dimension: 2}}},
+	}
+	for _, test := range tests {
+		got := parsePattern(test.pattern, "test", flavorDimensions)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parsePattern(%q) = %+v, want %+v", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestRenderVariant(t *testing.T) {
+	flavorDimensions := map[int]FlavorDimension{1: {Index: 1, Name: "tier"}, 2: {Index: 2, Name: "color"}}
+	tests := []struct {
+		pattern string
+		variant Variant
+		want    string
+	}{
+		{"assemble#1#2Release", Variant{"free_trial", "blue"}, "assembleFree_trialBlueRelease"},
+		{"#1#2Release", Variant{"QA", "blue"}, "QABlueRelease"},
+		{"#1#2Release", Variant{"HDfull", "teal"}, "HDfullTealRelease"},
+		{"#1:kebab-#2:upper", Variant{"HDfull", "darkBlue"}, "hdfull-DARK_BLUE"},
+		{"assemble#{tier:pascal}#{color}:pascal", Variant{"free trial", "dark-blue"}, "assembleFreeTrialDarkBlue"},
+	}
+	for _, test := range tests {
+		variantPattern := VariantPattern{Parts: parsePattern(test.pattern, "test", flavorDimensions)}
+		if got := renderVariant(variantPattern, test.variant); got != test.want {
+			t.Errorf("renderVariant(%q, %v) = %q, want %q", test.pattern, test.variant, got, test.want)
+		}
+	}
+}